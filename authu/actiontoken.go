@@ -0,0 +1,208 @@
+package authu
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var ErrActionTokenInvalid = errors.New("action token invalid")
+var ErrActionTokenUsed = errors.New("action token already used")
+
+const fingerprintKey = "fp"
+
+// TokenSigner signs and verifies JWTs. JWTAuth implements it, as does HMACTokenSigner
+// for services without a JWTAuth.
+type TokenSigner interface {
+	Sign(claims jwt.MapClaims) (string, error)
+	Verify(token string, opts ...ClaimsOption) (jwt.MapClaims, error)
+}
+
+// ConsumedTokenStore records used action tokens by jti.
+type ConsumedTokenStore interface {
+	// Consume atomically marks jti as used until expiresAt, returning false if it
+	// already was.
+	Consume(ctx context.Context, jti string, expiresAt time.Time) (bool, error)
+}
+
+// ActionTokens issues signed URL-safe tokens for links sent by email, such as email
+// verification and password reset. Each token is bound to a purpose, a subject and an
+// expiry, and is only accepted for that purpose, never as an access token.
+//
+// A fingerprint of the user's current state, e.g. their password hash, can be bound
+// into the token so that it dies as soon as that state changes. It should be high
+// entropy, as its salted hash is readable in the token.
+type ActionTokens[K any] struct {
+	Signer TokenSigner
+	// Consumed optionally makes tokens single use.
+	Consumed ConsumedTokenStore
+}
+
+func NewActionTokens[K any](signer TokenSigner, consumed ConsumedTokenStore) *ActionTokens[K] {
+	return &ActionTokens[K]{Signer: signer, Consumed: consumed}
+}
+
+// Issue creates a token for purpose and sub valid for ttl. fingerprint may be nil.
+func (a *ActionTokens[K]) Issue(purpose string, sub K, ttl time.Duration, fingerprint []byte) (string, error) {
+	if purpose == "" {
+		return "", fmt.Errorf("missing action token purpose")
+	}
+	encodedSub, err := encodeJWTSubject(sub)
+	if err != nil {
+		return "", err
+	}
+	jti, err := GenerateRandomToken(16)
+	if err != nil {
+		return "", fmt.Errorf("generating jti: %w", err)
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		purposeKey: purpose,
+		"sub":      encodedSub,
+		"jti":      jti,
+		"iat":      now.Unix(),
+		"exp":      now.Add(ttl).Unix(),
+	}
+	if fingerprint != nil {
+		claims[fingerprintKey] = hashFingerprint(jti, fingerprint)
+	}
+	return a.Signer.Sign(claims)
+}
+
+// Verify checks the token without using it up, e.g. before showing a password reset
+// form. fingerprint loads the subject's current fingerprint and is only called for
+// tokens issued with one.
+func (a *ActionTokens[K]) Verify(token string, purpose string, fingerprint func(sub K) ([]byte, error)) (K, jwt.MapClaims, error) {
+	var zero K
+	if purpose == "" {
+		return zero, nil, ErrWrongPurpose
+	}
+	claims, err := a.Signer.Verify(token, withPurpose(purpose))
+	if err != nil {
+		return zero, nil, err
+	}
+	encodedSub, _ := claims["sub"].(string)
+	jti, _ := claims["jti"].(string)
+	if encodedSub == "" || jti == "" {
+		return zero, nil, ErrActionTokenInvalid
+	}
+	sub, err := decodeJWTSubject[K](encodedSub)
+	if err != nil {
+		return zero, nil, err
+	}
+	if fp, ok := claims[fingerprintKey].(string); ok {
+		if fingerprint == nil {
+			return zero, nil, fmt.Errorf("%w: fingerprint required", ErrActionTokenInvalid)
+		}
+		current, err := fingerprint(sub)
+		if err != nil {
+			return zero, nil, fmt.Errorf("loading fingerprint: %w", err)
+		}
+		if subtle.ConstantTimeCompare([]byte(hashFingerprint(jti, current)), []byte(fp)) != 1 {
+			return zero, nil, fmt.Errorf("%w: fingerprint changed", ErrActionTokenInvalid)
+		}
+	}
+	return sub, claims, nil
+}
+
+// Consume verifies the token and, with Consumed set, marks it used so that a second
+// Consume fails with ErrActionTokenUsed.
+func (a *ActionTokens[K]) Consume(ctx context.Context, token string, purpose string, fingerprint func(sub K) ([]byte, error)) (K, error) {
+	var zero K
+	sub, claims, err := a.Verify(token, purpose, fingerprint)
+	if err != nil {
+		return zero, err
+	}
+	if a.Consumed == nil {
+		return sub, nil
+	}
+	exp, _, err := claimTime(claims, "exp")
+	if err != nil {
+		return zero, err
+	}
+	fresh, err := a.Consumed.Consume(ctx, claims["jti"].(string), exp)
+	if err != nil {
+		return zero, fmt.Errorf("consuming action token: %w", err)
+	}
+	if !fresh {
+		return zero, ErrActionTokenUsed
+	}
+	return sub, nil
+}
+
+// hashFingerprint salts with the jti so equal fingerprints don't give equal claims.
+func hashFingerprint(jti string, fingerprint []byte) string {
+	sum := sha256.Sum256(bytes.Join([][]byte{[]byte(jti), fingerprint}, []byte{0}))
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+// HMACTokenSigner is a TokenSigner using HS256 with a fixed shared key.
+type HMACTokenSigner struct {
+	Key    []byte
+	claims claimsConfig
+}
+
+func NewHMACTokenSigner(key []byte, opts ...ClaimsOption) (*HMACTokenSigner, error) {
+	if len(key) < 32 {
+		return nil, fmt.Errorf("hmac key must be at least 32 bytes")
+	}
+	return &HMACTokenSigner{Key: key, claims: claimsConfig{}.with(opts)}, nil
+}
+
+func (s *HMACTokenSigner) Sign(claims jwt.MapClaims) (string, error) {
+	if err := s.claims.applyStandardClaims(claims); err != nil {
+		return "", err
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.Key)
+}
+
+func (s *HMACTokenSigner) Verify(token string, opts ...ClaimsOption) (jwt.MapClaims, error) {
+	parsed, err := jwt.NewParser(jwt.WithoutClaimsValidation(), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()})).
+		Parse(token, func(*jwt.Token) (any, error) { return s.Key, nil })
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || !parsed.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if err := s.claims.with(opts).validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// InMemoryConsumedTokenStore is a ConsumedTokenStore for single instance deployments.
+// Entries are dropped once the token has expired.
+type InMemoryConsumedTokenStore struct {
+	mu   sync.Mutex
+	jtis map[string]time.Time
+}
+
+func NewInMemoryConsumedTokenStore() *InMemoryConsumedTokenStore {
+	return &InMemoryConsumedTokenStore{jtis: map[string]time.Time{}}
+}
+
+func (s *InMemoryConsumedTokenStore) Consume(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, exp := range s.jtis {
+		if now.After(exp) {
+			delete(s.jtis, k)
+		}
+	}
+	if _, ok := s.jtis[jti]; ok {
+		return false, nil
+	}
+	s.jtis[jti] = expiresAt
+	return true, nil
+}
@@ -0,0 +1,87 @@
+package authu
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActionTokensSingleUse(t *testing.T) {
+	ctx := context.Background()
+	signer, err := NewHMACTokenSigner(bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatalf("NewHMACTokenSigner: %v", err)
+	}
+	tokens := NewActionTokens[string](signer, NewInMemoryConsumedTokenStore())
+	token, err := tokens.Issue("verify-email", "alice", time.Hour, nil)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if strings.ContainsAny(token, "+/=") {
+		t.Fatalf("token not url safe: %s", token)
+	}
+
+	if _, err := tokens.Consume(ctx, token, "reset-password", nil); !errors.Is(err, ErrWrongPurpose) {
+		t.Fatalf("Consume wrong purpose err = %v", err)
+	}
+	sub, err := tokens.Consume(ctx, token, "verify-email", nil)
+	if err != nil || sub != "alice" {
+		t.Fatalf("Consume = %v, %v", sub, err)
+	}
+	if _, err := tokens.Consume(ctx, token, "verify-email", nil); !errors.Is(err, ErrActionTokenUsed) {
+		t.Fatalf("second Consume err = %v", err)
+	}
+	if _, err := signer.Verify(token); !errors.Is(err, ErrWrongPurpose) {
+		t.Fatalf("action token verified as access token, err = %v", err)
+	}
+
+	expired, _ := tokens.Issue("verify-email", "alice", -time.Minute, nil)
+	if _, err := tokens.Consume(ctx, expired, "verify-email", nil); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("Consume expired err = %v", err)
+	}
+}
+
+func TestActionTokensFingerprintWithJWTAuth(t *testing.T) {
+	ctx := context.Background()
+	auth := newJWTTestAuth(t)
+	tokens := NewActionTokens[string](auth, nil)
+	passwordHash := []byte("$argon2id$old")
+	fingerprint := func(sub string) ([]byte, error) { return passwordHash, nil }
+
+	token, err := tokens.Issue("reset-password", "alice", time.Hour, passwordHash)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if _, _, err := auth.VerifyAndResolveUser(token); !errors.Is(err, ErrWrongPurpose) {
+		t.Fatalf("action token accepted by JWTAuth, err = %v", err)
+	}
+	if _, _, err := tokens.Verify(token, "reset-password", nil); !errors.Is(err, ErrActionTokenInvalid) {
+		t.Fatalf("Verify without fingerprint err = %v", err)
+	}
+	if sub, err := tokens.Consume(ctx, token, "reset-password", fingerprint); err != nil || sub != "alice" {
+		t.Fatalf("Consume = %v, %v", sub, err)
+	}
+	// the password changed, so the link no longer works
+	passwordHash = []byte("$argon2id$new")
+	if _, err := tokens.Consume(ctx, token, "reset-password", fingerprint); !errors.Is(err, ErrActionTokenInvalid) {
+		t.Fatalf("Consume after password change err = %v", err)
+	}
+}
+
+func TestPlainVerifyAcceptsOtherPurposeClaims(t *testing.T) {
+	auth := newJWTTestAuth(t)
+	token, err := auth.Sign(map[string]any{"sub": "alice", "purpose": "billing", "exp": time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	claims, err := auth.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify token with a purpose claim of its own: %v", err)
+	}
+	if claims["purpose"] != "billing" {
+		t.Fatalf("purpose = %v", claims["purpose"])
+	}
+}
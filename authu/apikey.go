@@ -0,0 +1,276 @@
+package authu
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var ErrAPIKeyInvalid = errors.New("api key invalid")
+var ErrAPIKeyExpired = errors.New("api key expired")
+
+const (
+	apiKeyIDLength       = 12 // GenerateRandomToken(9)
+	apiKeySecretLength   = 43 // GenerateRandomToken(32)
+	apiKeyChecksumLength = 6  // base64url crc32
+)
+
+// APIKeyRecord is what an APIKeyStore keeps per issued key. The secret itself is never
+// stored, only its hash.
+type APIKeyRecord struct {
+	ID         string
+	Subject    string
+	Name       string
+	SecretHash string
+	Scopes     []string
+	CreatedAt  time.Time
+	// ExpiresAt is zero for keys that don't expire.
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+}
+
+// Claims presents the key like a verified JWT, so ContextWithAuth and scope checks
+// work the same for both.
+func (r APIKeyRecord) Claims() jwt.MapClaims {
+	claims := jwt.MapClaims{"sub": r.Subject, "scopes": slices.Clone(r.Scopes), "iat": r.CreatedAt.Unix()}
+	if !r.ExpiresAt.IsZero() {
+		claims["exp"] = r.ExpiresAt.Unix()
+	}
+	return claims
+}
+
+type APIKeyStore interface {
+	Save(ctx context.Context, record APIKeyRecord) error
+	// Load returns ErrAPIKeyInvalid when no record exists for id.
+	Load(ctx context.Context, id string) (APIKeyRecord, error)
+	List(ctx context.Context, subject string) ([]APIKeyRecord, error)
+	// Delete removes the record only when it belongs to subject, checked in the same
+	// step, and returns ErrAPIKeyInvalid otherwise.
+	Delete(ctx context.Context, subject string, id string) error
+	TouchLastUsed(ctx context.Context, id string, at time.Time) error
+}
+
+// APIKeys issues long-lived keys for machine clients that, unlike JWTs, can be listed
+// and revoked individually. Keys look like <Prefix>_<id>_<secret><checksum>; the
+// prefix and crc32 checksum let secret scanners recognise leaked keys without a
+// lookup, and the id finds the record without scanning hashes.
+type APIKeys[K any] struct {
+	Prefix string
+	Store  APIKeyStore
+	// LastUsedInterval limits how often LastUsedAt is written for busy keys.
+	LastUsedInterval time.Duration
+	// Logger reports errors that don't fail the call, slog.Default() when nil.
+	Logger *slog.Logger
+}
+
+func NewAPIKeys[K any](prefix string, store APIKeyStore) *APIKeys[K] {
+	return &APIKeys[K]{
+		Prefix:           prefix,
+		Store:            store,
+		LastUsedInterval: time.Minute,
+	}
+}
+
+// Issue creates a key for sub, returning the key to hand to the client once and the
+// stored record. A ttl of 0 issues a key that never expires.
+func (k *APIKeys[K]) Issue(ctx context.Context, sub K, name string, scopes []string, ttl time.Duration) (string, APIKeyRecord, error) {
+	encodedSub, err := encodeJWTSubject(sub)
+	if err != nil {
+		return "", APIKeyRecord{}, err
+	}
+	id, err := GenerateRandomToken(9)
+	if err != nil {
+		return "", APIKeyRecord{}, fmt.Errorf("generating api key id: %w", err)
+	}
+	secret, err := GenerateRandomToken(32)
+	if err != nil {
+		return "", APIKeyRecord{}, fmt.Errorf("generating api key secret: %w", err)
+	}
+	now := time.Now()
+	record := APIKeyRecord{
+		ID:         id,
+		Subject:    encodedSub,
+		Name:       name,
+		SecretHash: hashAPIKeySecret(secret),
+		Scopes:     slices.Clone(scopes),
+		CreatedAt:  now,
+	}
+	if ttl > 0 {
+		record.ExpiresAt = now.Add(ttl)
+	}
+	if err := k.Store.Save(ctx, record); err != nil {
+		return "", APIKeyRecord{}, fmt.Errorf("saving api key: %w", err)
+	}
+	body := k.Prefix + "_" + id + "_" + secret
+	return body + apiKeyChecksum(body), record, nil
+}
+
+// Verify checks the key and that it carries every required scope, returning its record
+// and decoded subject.
+func (k *APIKeys[K]) Verify(ctx context.Context, key string, requiredScopes ...string) (APIKeyRecord, K, error) {
+	var zero K
+	id, secret, err := k.parse(key)
+	if err != nil {
+		return APIKeyRecord{}, zero, err
+	}
+	record, err := k.Store.Load(ctx, id)
+	if err != nil {
+		return APIKeyRecord{}, zero, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKeySecret(secret)), []byte(record.SecretHash)) != 1 {
+		return APIKeyRecord{}, zero, ErrAPIKeyInvalid
+	}
+	now := time.Now()
+	if !record.ExpiresAt.IsZero() && now.After(record.ExpiresAt) {
+		return APIKeyRecord{}, zero, ErrAPIKeyExpired
+	}
+	for _, required := range requiredScopes {
+		if !slices.Contains(record.Scopes, required) {
+			return APIKeyRecord{}, zero, fmt.Errorf("%w: '%v'", ErrMissingScope, required)
+		}
+	}
+	sub, err := decodeJWTSubject[K](record.Subject)
+	if err != nil {
+		return APIKeyRecord{}, zero, err
+	}
+	if now.Sub(record.LastUsedAt) >= k.LastUsedInterval {
+		// LastUsedAt is informational, so a failed write doesn't refuse a valid key
+		if err := k.Store.TouchLastUsed(ctx, id, now); err != nil {
+			k.logger().Error("updating api key last used", "id", id, "error", err)
+		} else {
+			record.LastUsedAt = now
+		}
+	}
+	return record, sub, nil
+}
+
+func (k *APIKeys[K]) List(ctx context.Context, sub K) ([]APIKeyRecord, error) {
+	encodedSub, err := encodeJWTSubject(sub)
+	if err != nil {
+		return nil, err
+	}
+	return k.Store.List(ctx, encodedSub)
+}
+
+// Revoke deletes the key id of sub, returning ErrAPIKeyInvalid when sub has no such key
+// so one subject can't revoke another's keys.
+func (k *APIKeys[K]) Revoke(ctx context.Context, sub K, id string) error {
+	encodedSub, err := encodeJWTSubject(sub)
+	if err != nil {
+		return err
+	}
+	return k.Store.Delete(ctx, encodedSub, id)
+}
+
+// IsAPIKey reports whether s is shaped like a key from these APIKeys with a valid
+// checksum, without any store lookup.
+func (k *APIKeys[K]) IsAPIKey(s string) bool {
+	_, _, err := k.parse(s)
+	return err == nil
+}
+
+func (k *APIKeys[K]) logger() *slog.Logger {
+	if k.Logger != nil {
+		return k.Logger
+	}
+	return slog.Default()
+}
+
+// parse splits a key by position rather than by separator, as the base64url id and
+// secret may themselves contain underscores.
+func (k *APIKeys[K]) parse(key string) (string, string, error) {
+	rest, ok := strings.CutPrefix(key, k.Prefix+"_")
+	if !ok || len(rest) != apiKeyIDLength+1+apiKeySecretLength+apiKeyChecksumLength || rest[apiKeyIDLength] != '_' {
+		return "", "", ErrAPIKeyInvalid
+	}
+	body, checksum := key[:len(key)-apiKeyChecksumLength], key[len(key)-apiKeyChecksumLength:]
+	if subtle.ConstantTimeCompare([]byte(apiKeyChecksum(body)), []byte(checksum)) != 1 {
+		return "", "", ErrAPIKeyInvalid
+	}
+	return rest[:apiKeyIDLength], rest[apiKeyIDLength+1 : apiKeyIDLength+1+apiKeySecretLength], nil
+}
+
+func apiKeyChecksum(body string) string {
+	return base64.RawURLEncoding.EncodeToString(binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE([]byte(body))))
+}
+
+// hashAPIKeySecret uses a plain sha256 as secrets are high entropy random values.
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// InMemoryAPIKeyStore is an APIKeyStore for single instance deployments and tests.
+type InMemoryAPIKeyStore struct {
+	mu      sync.Mutex
+	records map[string]APIKeyRecord
+}
+
+func NewInMemoryAPIKeyStore() *InMemoryAPIKeyStore {
+	return &InMemoryAPIKeyStore{records: map[string]APIKeyRecord{}}
+}
+
+func (s *InMemoryAPIKeyStore) Save(ctx context.Context, record APIKeyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.ID] = record
+	return nil
+}
+
+func (s *InMemoryAPIKeyStore) Load(ctx context.Context, id string) (APIKeyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[id]
+	if !ok {
+		return APIKeyRecord{}, ErrAPIKeyInvalid
+	}
+	return record, nil
+}
+
+func (s *InMemoryAPIKeyStore) List(ctx context.Context, subject string) ([]APIKeyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []APIKeyRecord
+	for _, record := range s.records {
+		if record.Subject == subject {
+			out = append(out, record)
+		}
+	}
+	slices.SortFunc(out, func(a, b APIKeyRecord) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return out, nil
+}
+
+func (s *InMemoryAPIKeyStore) Delete(ctx context.Context, subject string, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[id]
+	if !ok || record.Subject != subject {
+		return ErrAPIKeyInvalid
+	}
+	delete(s.records, id)
+	return nil
+}
+
+func (s *InMemoryAPIKeyStore) TouchLastUsed(ctx context.Context, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[id]
+	if !ok {
+		return ErrAPIKeyInvalid
+	}
+	record.LastUsedAt = at
+	s.records[id] = record
+	return nil
+}
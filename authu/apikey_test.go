@@ -0,0 +1,112 @@
+package authu
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIKeysIssueAndVerify(t *testing.T) {
+	ctx := context.Background()
+	keys := NewAPIKeys[string]("gu", NewInMemoryAPIKeyStore())
+	key, record, err := keys.Issue(ctx, "alice", "ci", []string{"orders:read"}, 0)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if !strings.HasPrefix(key, "gu_"+record.ID+"_") || !keys.IsAPIKey(key) {
+		t.Fatalf("key = %q", key)
+	}
+
+	verified, sub, err := keys.Verify(ctx, key, "orders:read")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if sub != "alice" || verified.LastUsedAt.IsZero() {
+		t.Fatalf("Verify = %+v, %v", verified, sub)
+	}
+	if scopes := claimStrings(verified.Claims()["scopes"]); len(scopes) != 1 || scopes[0] != "orders:read" {
+		t.Fatalf("claims scopes = %v", scopes)
+	}
+	if _, _, err := keys.Verify(ctx, key, "orders:write"); !errors.Is(err, ErrMissingScope) {
+		t.Fatalf("Verify missing scope err = %v", err)
+	}
+
+	listed, err := keys.List(ctx, "alice")
+	if err != nil || len(listed) != 1 || listed[0].LastUsedAt.IsZero() {
+		t.Fatalf("List = %+v, %v", listed, err)
+	}
+	if err := keys.Revoke(ctx, "mallory", record.ID); !errors.Is(err, ErrAPIKeyInvalid) {
+		t.Fatalf("Revoke by other subject err = %v, want ErrAPIKeyInvalid", err)
+	}
+	if _, _, err := keys.Verify(ctx, key); err != nil {
+		t.Fatalf("Verify after foreign Revoke: %v", err)
+	}
+	if err := keys.Revoke(ctx, "alice", record.ID); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, _, err := keys.Verify(ctx, key); !errors.Is(err, ErrAPIKeyInvalid) {
+		t.Fatalf("Verify revoked err = %v", err)
+	}
+}
+
+func TestAPIKeysRejectsTamperedAndExpiredKeys(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryAPIKeyStore()
+	keys := NewAPIKeys[string]("gu", store)
+	key, record, err := keys.Issue(ctx, "alice", "ci", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	tampered := []byte(key)
+	tampered[len("gu_")+apiKeyIDLength+5] ^= 1
+	if keys.IsAPIKey(string(tampered)) {
+		t.Fatalf("tampered key passes checksum")
+	}
+	if _, _, err := keys.Verify(ctx, string(tampered)); !errors.Is(err, ErrAPIKeyInvalid) {
+		t.Fatalf("Verify tampered err = %v", err)
+	}
+	if _, _, err := NewAPIKeys[string]("other", store).Verify(ctx, key); !errors.Is(err, ErrAPIKeyInvalid) {
+		t.Fatalf("Verify wrong prefix err = %v", err)
+	}
+
+	// a key with a valid checksum but the wrong secret
+	forged := "gu_" + record.ID + "_" + strings.Repeat("A", apiKeySecretLength)
+	if _, _, err := keys.Verify(ctx, forged+apiKeyChecksum(forged)); !errors.Is(err, ErrAPIKeyInvalid) {
+		t.Fatalf("Verify forged err = %v", err)
+	}
+
+	record.ExpiresAt = time.Now().Add(-time.Second)
+	_ = store.Save(ctx, record)
+	if _, _, err := keys.Verify(ctx, key); !errors.Is(err, ErrAPIKeyExpired) {
+		t.Fatalf("Verify expired err = %v", err)
+	}
+}
+
+type failingTouchAPIKeyStore struct {
+	*InMemoryAPIKeyStore
+}
+
+func (s failingTouchAPIKeyStore) TouchLastUsed(ctx context.Context, id string, at time.Time) error {
+	return errors.New("store read only")
+}
+
+func TestAPIKeysVerifyIgnoresLastUsedFailure(t *testing.T) {
+	ctx := context.Background()
+	keys := NewAPIKeys[string]("gu", failingTouchAPIKeyStore{NewInMemoryAPIKeyStore()})
+	keys.Logger = slog.New(slog.DiscardHandler)
+	key, _, err := keys.Issue(ctx, "alice", "ci", nil, 0)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	record, sub, err := keys.Verify(ctx, key)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if sub != "alice" || !record.LastUsedAt.IsZero() {
+		t.Fatalf("Verify = %+v, %v", record, sub)
+	}
+}
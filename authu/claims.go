@@ -0,0 +1,244 @@
+package authu
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var ErrTokenExpired = errors.New("token expired")
+var ErrTokenNotYetValid = errors.New("token not yet valid")
+var ErrTokenTooOld = errors.New("token too old")
+var ErrWrongIssuer = errors.New("token issuer mismatch")
+var ErrWrongAudience = errors.New("token audience mismatch")
+var ErrMissingScope = errors.New("token missing scope")
+var ErrMissingClaim = errors.New("token missing claim")
+var ErrWrongPurpose = errors.New("token purpose mismatch")
+
+// purposeKey is namespaced so it can't clash with a purpose claim of other issuers or
+// callers.
+const purposeKey = "authu_action_purpose"
+
+// ClaimsOption configures standard claim handling. Passed to NewJWTAuth it sets the
+// defaults used when minting and verifying tokens; passed to Verify it overrides them
+// for that call only.
+type ClaimsOption func(*claimsConfig)
+
+type claimsConfig struct {
+	issuer           string
+	audience         []string
+	leeway           time.Duration
+	maxAge           time.Duration
+	requiredScopes   []string
+	requireNotBefore bool
+	// purpose must match the purpose claim when set. When unset, tokens with a purpose
+	// claim are rejected, so action tokens are never accepted as access tokens.
+	purpose string
+	clock   func() time.Time
+}
+
+// WithIssuer sets the iss claim on minted tokens and requires it on verification.
+func WithIssuer(issuer string) ClaimsOption {
+	return func(c *claimsConfig) {
+		c.issuer = issuer
+	}
+}
+
+// WithAudience sets the aud claim on minted tokens and requires verified tokens to
+// carry at least one of the given audiences.
+func WithAudience(audience ...string) ClaimsOption {
+	return func(c *claimsConfig) {
+		c.audience = slices.Clone(audience)
+	}
+}
+
+// WithLeeway allows for clock skew between issuer and verifier when checking exp, nbf
+// and iat.
+func WithLeeway(leeway time.Duration) ClaimsOption {
+	return func(c *claimsConfig) {
+		c.leeway = leeway
+	}
+}
+
+// WithMaxAge rejects tokens issued (iat) longer ago than maxAge, regardless of exp.
+func WithMaxAge(maxAge time.Duration) ClaimsOption {
+	return func(c *claimsConfig) {
+		c.maxAge = maxAge
+	}
+}
+
+// WithRequiredScopes requires every given scope to be present in the scopes claim.
+func WithRequiredScopes(scopes ...string) ClaimsOption {
+	return func(c *claimsConfig) {
+		c.requiredScopes = slices.Clone(scopes)
+	}
+}
+
+// WithRequireNotBefore rejects tokens without an nbf claim. Present nbf claims are
+// always checked.
+func WithRequireNotBefore() ClaimsOption {
+	return func(c *claimsConfig) {
+		c.requireNotBefore = true
+	}
+}
+
+func withPurpose(purpose string) ClaimsOption {
+	return func(c *claimsConfig) {
+		c.purpose = purpose
+	}
+}
+
+func (c claimsConfig) now() time.Time {
+	if c.clock != nil {
+		return c.clock()
+	}
+	return time.Now()
+}
+
+func (c claimsConfig) with(opts []ClaimsOption) claimsConfig {
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// applyStandardClaims fills in the configured iss and aud plus a jti and iat when the
+// caller didn't set them.
+func (c claimsConfig) applyStandardClaims(claims jwt.MapClaims) error {
+	if _, ok := claims["iss"]; !ok && c.issuer != "" {
+		claims["iss"] = c.issuer
+	}
+	if _, ok := claims["aud"]; !ok && len(c.audience) > 0 {
+		if len(c.audience) == 1 {
+			claims["aud"] = c.audience[0]
+		} else {
+			claims["aud"] = c.audience
+		}
+	}
+	if _, ok := claims["iat"]; !ok {
+		claims["iat"] = c.now().Unix()
+	}
+	if _, ok := claims["jti"]; !ok {
+		jti, err := GenerateRandomToken(16)
+		if err != nil {
+			return fmt.Errorf("generating jti: %w", err)
+		}
+		claims["jti"] = jti
+	}
+	return nil
+}
+
+func (c claimsConfig) validate(claims jwt.MapClaims) error {
+	now := c.now()
+
+	purpose, hasPurpose := claims[purposeKey]
+	if (c.purpose != "" || hasPurpose) && purpose != c.purpose {
+		return fmt.Errorf("%w: '%v'", ErrWrongPurpose, purpose)
+	}
+
+	exp, ok, err := claimTime(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if ok && now.After(exp.Add(c.leeway)) {
+		return ErrTokenExpired
+	}
+
+	nbf, ok, err := claimTime(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if !ok && c.requireNotBefore {
+		return fmt.Errorf("%w 'nbf'", ErrMissingClaim)
+	}
+	if ok && now.Add(c.leeway).Before(nbf) {
+		return ErrTokenNotYetValid
+	}
+
+	iat, ok, err := claimTime(claims, "iat")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(c.leeway).Before(iat) {
+		return ErrTokenNotYetValid
+	}
+	if c.maxAge > 0 {
+		if !ok {
+			return fmt.Errorf("%w 'iat'", ErrMissingClaim)
+		}
+		if now.Sub(iat) > c.maxAge+c.leeway {
+			return ErrTokenTooOld
+		}
+	}
+
+	if c.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != c.issuer {
+			return fmt.Errorf("%w: '%v'", ErrWrongIssuer, iss)
+		}
+	}
+
+	if len(c.audience) > 0 {
+		audiences := claimStrings(claims["aud"])
+		if !slices.ContainsFunc(audiences, func(aud string) bool { return slices.Contains(c.audience, aud) }) {
+			return fmt.Errorf("%w: %v", ErrWrongAudience, audiences)
+		}
+	}
+
+	if len(c.requiredScopes) > 0 {
+		scopes := claimStrings(claims["scopes"])
+		for _, required := range c.requiredScopes {
+			if !slices.Contains(scopes, required) {
+				return fmt.Errorf("%w '%v'", ErrMissingScope, required)
+			}
+		}
+	}
+	return nil
+}
+
+// claimTime reads a NumericDate claim, reporting whether it was present.
+func claimTime(claims jwt.MapClaims, key string) (time.Time, bool, error) {
+	var sec float64
+	switch v := claims[key].(type) {
+	case nil:
+		return time.Time{}, false, nil
+	case float64:
+		sec = v
+	case int64:
+		sec = float64(v)
+	case int:
+		sec = float64(v)
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid '%v' claim: %w", key, err)
+		}
+		sec = f
+	default:
+		return time.Time{}, false, fmt.Errorf("invalid '%v' claim type %T", key, v)
+	}
+	return time.Unix(0, int64(sec*float64(time.Second))), true, nil
+}
+
+// claimStrings reads a claim that may be a single string or an array of strings, as
+// aud and scopes are.
+func claimStrings(v any) []string {
+	switch vv := v.(type) {
+	case string:
+		return []string{vv}
+	case []string:
+		return vv
+	case []any:
+		out := make([]string, 0, len(vv))
+		for _, item := range vv {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package authu
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestJWTAuthMintsStandardClaims(t *testing.T) {
+	auth := newJWTTestAuth(t)
+	auth.claims = auth.claims.with([]ClaimsOption{WithIssuer("https://issuer.example"), WithAudience("api")})
+
+	token, err := auth.GenerateTokenWith("user-1", []string{"orders:read"}, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateTokenWith: %v", err)
+	}
+	claims, err := auth.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	for _, key := range []string{"iss", "aud", "nbf", "iat", "jti"} {
+		if _, ok := claims[key]; !ok {
+			t.Fatalf("missing %v claim in %#v", key, claims)
+		}
+	}
+	if claims["iss"] != "https://issuer.example" || claims["aud"] != "api" {
+		t.Fatalf("claims = %#v", claims)
+	}
+}
+
+func TestJWTAuthVerifyClaimErrors(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		opts   []ClaimsOption
+		want   error
+	}{
+		{
+			name:   "expired",
+			claims: jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()},
+			want:   ErrTokenExpired,
+		},
+		{
+			name:   "expired within leeway",
+			claims: jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()},
+			opts:   []ClaimsOption{WithLeeway(2 * time.Minute)},
+		},
+		{
+			name:   "not yet valid",
+			claims: jwt.MapClaims{"nbf": now.Add(time.Minute).Unix()},
+			want:   ErrTokenNotYetValid,
+		},
+		{
+			name:   "missing nbf",
+			claims: jwt.MapClaims{"nbf": nil},
+			opts:   []ClaimsOption{WithRequireNotBefore()},
+			want:   ErrMissingClaim,
+		},
+		{
+			name:   "too old",
+			claims: jwt.MapClaims{"iat": now.Add(-2 * time.Hour).Unix()},
+			opts:   []ClaimsOption{WithMaxAge(time.Hour)},
+			want:   ErrTokenTooOld,
+		},
+		{
+			name:   "wrong issuer",
+			claims: jwt.MapClaims{"iss": "other"},
+			opts:   []ClaimsOption{WithIssuer("me")},
+			want:   ErrWrongIssuer,
+		},
+		{
+			name:   "wrong audience",
+			claims: jwt.MapClaims{"aud": []string{"web", "mobile"}},
+			opts:   []ClaimsOption{WithAudience("api")},
+			want:   ErrWrongAudience,
+		},
+		{
+			name:   "one of several audiences",
+			claims: jwt.MapClaims{"aud": []string{"web", "api"}},
+			opts:   []ClaimsOption{WithAudience("api")},
+		},
+		{
+			name:   "missing scope",
+			claims: jwt.MapClaims{"scopes": []string{"orders:read"}},
+			opts:   []ClaimsOption{WithRequiredScopes("orders:read", "orders:write")},
+			want:   ErrMissingScope,
+		},
+	}
+
+	auth := newJWTTestAuth(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := jwt.MapClaims{"sub": "user-1", "nbf": now.Unix()}
+			for k, v := range tt.claims {
+				if v == nil {
+					delete(claims, k)
+					continue
+				}
+				claims[k] = v
+			}
+			token, err := auth.Sign(claims)
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+			_, err = auth.Verify(token, tt.opts...)
+			if tt.want == nil && err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("Verify err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
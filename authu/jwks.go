@@ -0,0 +1,452 @@
+package authu
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+var ErrUnknownKid = errors.New("unknown kid")
+
+// JWK is a single RFC 7517 JSON Web Key holding a public verification key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is an RFC 7517 JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK encodes a public key as a JWK. Symmetric (HMAC) keys can't be published and
+// are rejected.
+func NewJWK(kid string, alg string, key any) (JWK, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: alg,
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		point, err := k.Bytes()
+		if err != nil {
+			return JWK{}, fmt.Errorf("encoding ecdsa key: %w", err)
+		}
+		// uncompressed point: 0x04 || X || Y
+		size := (len(point) - 1) / 2
+		return JWK{
+			Kty: "EC",
+			Kid: kid,
+			Use: "sig",
+			Alg: alg,
+			Crv: k.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(point[1 : 1+size]),
+			Y:   base64.RawURLEncoding.EncodeToString(point[1+size:]),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Kid: kid,
+			Use: "sig",
+			Alg: alg,
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(k),
+		}, nil
+	default:
+		return JWK{}, fmt.Errorf("unsupported jwk key type %T", key)
+	}
+}
+
+// PublicKey decodes the JWK into a crypto public key.
+func (k JWK) PublicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("decoding jwk modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("decoding jwk exponent: %w", err)
+		}
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid rsa jwk")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported jwk crv '%v'", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("decoding jwk x: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("decoding jwk y: %w", err)
+		}
+		key, err := ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, fmt.Errorf("parsing ecdsa jwk: %w", err)
+		}
+		return key, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported jwk crv '%v'", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("decoding jwk x: %w", err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 jwk")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported jwk kty '%v'", k.Kty)
+	}
+}
+
+// JWKS returns the active signing key plus every non-retired key as a JSON Web Key Set.
+func (a *JWTAuth[T, K]) JWKS() (JWKS, error) {
+	ak, err := a.ensureActiveKey()
+	if err != nil {
+		return JWKS{}, err
+	}
+	kids := []string{ak.Kid}
+	seen := map[string]bool{ak.Kid: true}
+	addKid := func(kid string) {
+		if kid != "" && !seen[kid] && !a.isKeyRetired(kid) {
+			seen[kid] = true
+			kids = append(kids, kid)
+		}
+	}
+	a.CachedPublicKeys.Range(func(k, _ any) bool {
+		if kid, ok := k.(string); ok {
+			addKid(kid)
+		}
+		return true
+	})
+	if a.PublicKeyLister != nil {
+		stored, err := a.PublicKeyLister()
+		if err != nil {
+			return JWKS{}, fmt.Errorf("listing public keys: %w", err)
+		}
+		for _, kid := range stored {
+			addKid(kid)
+		}
+	}
+
+	set := JWKS{Keys: make([]JWK, 0, len(kids))}
+	for _, kid := range kids {
+		publicKey, err := a.publicKey(kid)
+		if err != nil {
+			// one unreadable key shouldn't take down the whole set
+			a.logger().Error("loading public key for jwks", "kid", kid, "error", err)
+			continue
+		}
+		if _, ok := publicKey.([]byte); ok {
+			// hmac secrets are never published
+			continue
+		}
+		jwk, err := NewJWK(kid, a.jwkAlg(publicKey), publicKey)
+		if err != nil {
+			return JWKS{}, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+// JWKSHandler serves the output of JWKS, typically mounted at /.well-known/jwks.json.
+func (a *JWTAuth[T, K]) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		set, err := a.JWKS()
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		b, err := json.Marshal(set)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		_, _ = w.Write(b)
+	})
+}
+
+// jwkAlg picks the alg advertised for a key. Keys from before a SigningMethod change
+// advertise the alg implied by their type, or none for RSA where it is ambiguous.
+func (a *JWTAuth[T, K]) jwkAlg(key any) string {
+	if keyMatchesAlg(a.SigningMethod.Alg(), key) {
+		return a.SigningMethod.Alg()
+	}
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return "ES256"
+		case elliptic.P384():
+			return "ES384"
+		case elliptic.P521():
+			return "ES512"
+		}
+	case ed25519.PublicKey:
+		return "EdDSA"
+	}
+	return ""
+}
+
+// isKeyRetired reports whether a non-active key has been rotated out for longer than
+// RetiredKeyGrace. Creation time is taken from the UUIDv7 kid; kids without an
+// embedded timestamp are never considered retired.
+func (a *JWTAuth[T, K]) isKeyRetired(kid string) bool {
+	if a.AutoRotateDuration <= 0 {
+		return false
+	}
+	created, ok := kidCreated(kid)
+	if !ok {
+		return false
+	}
+	return time.Since(created) > a.AutoRotateDuration+max(a.RetiredKeyGrace, 0)
+}
+
+func kidCreated(kid string) (time.Time, bool) {
+	id, err := uuid.Parse(kid)
+	if err != nil || id.Version() != 7 {
+		return time.Time{}, false
+	}
+	sec, nsec := id.Time().UnixTime()
+	return time.Unix(sec, nsec), true
+}
+
+// JWKSVerifier verifies tokens against a remote JSON Web Key Set, such as one served
+// by JWTAuth.JWKSHandler, without needing access to the issuer's key storage.
+type JWKSVerifier struct {
+	URL        string
+	HTTPClient *http.Client
+	// CacheTTL is how long a fetched key set is used before it is refreshed.
+	CacheTTL time.Duration
+	// MinRefreshInterval limits how often an unknown kid can trigger a refetch.
+	MinRefreshInterval time.Duration
+
+	mu          sync.Mutex
+	claims      claimsConfig
+	keys        map[string]jwksKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+	// refreshing is set while a fetch triggered by Verify runs, so concurrent
+	// verifications wait for the same fetch and the lock isn't held during it
+	refreshing *jwksRefresh
+}
+
+type jwksRefresh struct {
+	done chan struct{}
+	err  error
+}
+
+// jwksFetchTimeout bounds the fetches triggered by Verify, which have no context of
+// their own.
+const jwksFetchTimeout = 10 * time.Second
+
+type jwksKey struct {
+	alg string
+	key any
+}
+
+func NewJWKSVerifier(url string, opts ...ClaimsOption) *JWKSVerifier {
+	return &JWKSVerifier{
+		URL:                url,
+		HTTPClient:         &http.Client{Timeout: 10 * time.Second},
+		CacheTTL:           time.Hour,
+		MinRefreshInterval: 30 * time.Second,
+		claims:             claimsConfig{}.with(opts),
+	}
+}
+
+func (v *JWKSVerifier) Verify(jwtToken string, opts ...ClaimsOption) (jwt.MapClaims, error) {
+	token, err := jwt.NewParser(jwt.WithoutClaimsValidation()).Parse(jwtToken, v.loadKey)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if err := v.claims.with(opts).validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Refresh fetches the remote key set and replaces the cached keys.
+func (v *JWKSVerifier) Refresh(ctx context.Context) error {
+	v.mu.Lock()
+	v.lastAttempt = time.Now()
+	v.mu.Unlock()
+	keys, err := v.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	return nil
+}
+
+func (v *JWKSVerifier) loadKey(t *jwt.Token) (any, error) {
+	kid, ok := t.Header[kidKey].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing kid header")
+	}
+	k, err := v.key(kid)
+	if err != nil {
+		return nil, err
+	}
+	if k.alg != "" && k.alg != t.Method.Alg() {
+		return nil, fmt.Errorf("token alg '%v' does not match key alg '%v'", t.Method.Alg(), k.alg)
+	}
+	if !keyMatchesAlg(t.Method.Alg(), k.key) {
+		return nil, fmt.Errorf("token alg '%v' not allowed for key '%v'", t.Method.Alg(), kid)
+	}
+	return k.key, nil
+}
+
+// key returns the key for kid, refreshing the key set when it is stale or doesn't
+// hold kid. A stale key is served without waiting for the refresh, and still served
+// while the issuer is unreachable.
+func (v *JWKSVerifier) key(kid string) (jwksKey, error) {
+	v.mu.Lock()
+	k, ok := v.keys[kid]
+	if ok && time.Since(v.fetchedAt) <= v.CacheTTL {
+		v.mu.Unlock()
+		return k, nil
+	}
+	var refresh *jwksRefresh
+	if v.refreshing != nil || time.Since(v.lastAttempt) > v.MinRefreshInterval {
+		refresh = v.startRefreshLocked()
+	}
+	v.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+	if refresh == nil {
+		return jwksKey{}, fmt.Errorf("%w '%v'", ErrUnknownKid, kid)
+	}
+	<-refresh.done
+	if refresh.err != nil {
+		return jwksKey{}, refresh.err
+	}
+	v.mu.Lock()
+	k, ok = v.keys[kid]
+	v.mu.Unlock()
+	if !ok {
+		return jwksKey{}, fmt.Errorf("%w '%v'", ErrUnknownKid, kid)
+	}
+	return k, nil
+}
+
+// startRefreshLocked starts fetching the key set in the background, or returns the
+// fetch already running. Must be called with v.mu held.
+func (v *JWKSVerifier) startRefreshLocked() *jwksRefresh {
+	if v.refreshing != nil {
+		return v.refreshing
+	}
+	refresh := &jwksRefresh{done: make(chan struct{})}
+	v.refreshing = refresh
+	v.lastAttempt = time.Now()
+	go func() {
+		defer close(refresh.done)
+		ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+		defer cancel()
+		keys, err := v.fetch(ctx)
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		if err == nil {
+			v.keys = keys
+			v.fetchedAt = time.Now()
+		}
+		refresh.err = err
+		v.refreshing = nil
+	}()
+	return refresh
+}
+
+func (v *JWKSVerifier) fetch(ctx context.Context) (map[string]jwksKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating jwks request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	client := v.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching jwks: unexpected status %v", resp.StatusCode)
+	}
+	var set JWKS
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding jwks: %w", err)
+	}
+	keys := make(map[string]jwksKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		publicKey, err := jwk.PublicKey()
+		if err != nil {
+			// skip keys we can't use rather than failing the whole set
+			continue
+		}
+		keys[jwk.Kid] = jwksKey{alg: jwk.Alg, key: publicKey}
+	}
+	return keys, nil
+}
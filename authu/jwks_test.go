@@ -0,0 +1,187 @@
+package authu
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newJWTTestAuth(t *testing.T) *JWTAuth[string, string] {
+	t.Helper()
+	var mu sync.Mutex
+	stored := map[string][]byte{}
+	return NewJWTAuth[string, string](func(kid string, key []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		stored[kid] = key
+		return nil
+	}, func(kid string) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		key, ok := stored[kid]
+		if !ok {
+			return nil, errors.New("not found")
+		}
+		return key, nil
+	}, func(sub string) (string, error) {
+		return sub, nil
+	})
+}
+
+func TestJWTAuthJWKSPublishesActiveAndPreviousKeys(t *testing.T) {
+	auth := newJWTTestAuth(t)
+	first, err := auth.ensureActiveKey()
+	if err != nil {
+		t.Fatalf("ensureActiveKey: %v", err)
+	}
+	// force a rotation
+	auth.activeKey.Store(nil)
+	second, err := auth.ensureActiveKey()
+	if err != nil {
+		t.Fatalf("ensureActiveKey: %v", err)
+	}
+
+	set, err := auth.JWKS()
+	if err != nil {
+		t.Fatalf("JWKS: %v", err)
+	}
+	if len(set.Keys) != 2 || set.Keys[0].Kid != second.Kid || set.Keys[1].Kid != first.Kid {
+		t.Fatalf("keys = %#v", set.Keys)
+	}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || k.Alg != "RS256" || k.Use != "sig" || k.N == "" || k.E == "" {
+			t.Fatalf("unexpected jwk %#v", k)
+		}
+	}
+
+	auth.AutoRotateDuration = time.Nanosecond
+	auth.RetiredKeyGrace = 0
+	if !auth.isKeyRetired(first.Kid) {
+		t.Fatalf("expected kid %v to be retired", first.Kid)
+	}
+}
+
+func TestJWKSVerifierVerifiesTokensFromRemoteKeySet(t *testing.T) {
+	auth := newJWTTestAuth(t)
+	var fetches int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		auth.JWKSHandler().ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	token, err := auth.GenerateTokenWith("user-1", []string{"read"}, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateTokenWith: %v", err)
+	}
+
+	verifier := NewJWKSVerifier(server.URL)
+	claims, err := verifier.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if claims["sub"] != "user-1" {
+		t.Fatalf("sub = %v", claims["sub"])
+	}
+	if _, err := verifier.Verify(token); err != nil {
+		t.Fatalf("Verify cached: %v", err)
+	}
+	if fetches != 1 {
+		t.Fatalf("fetches = %v, want 1", fetches)
+	}
+
+	// tokens signed by a key the issuer doesn't publish are rejected
+	other := newJWTTestAuth(t)
+	foreign, err := other.GenerateTokenWith("user-1", nil, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateTokenWith: %v", err)
+	}
+	if _, err := verifier.Verify(foreign); !errors.Is(err, ErrUnknownKid) {
+		t.Fatalf("Verify foreign err = %v, want ErrUnknownKid", err)
+	}
+}
+
+func TestJWKSVerifierDoesNotBlockCachedKeysDuringRefresh(t *testing.T) {
+	auth := newJWTTestAuth(t)
+	release := make(chan struct{})
+	var blocked sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("slow") == "" {
+			auth.JWKSHandler().ServeHTTP(w, r)
+			return
+		}
+		blocked.Do(func() { <-release })
+		auth.JWKSHandler().ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	token, err := auth.GenerateTokenWith("user-1", nil, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateTokenWith: %v", err)
+	}
+	verifier := NewJWKSVerifier(server.URL)
+	verifier.MinRefreshInterval = 0
+	if _, err := verifier.Verify(token); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	// an unknown kid triggers a refresh that hangs on the slow endpoint
+	verifier.URL = server.URL + "?slow=1"
+	foreign, err := newJWTTestAuth(t).GenerateTokenWith("user-1", nil, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateTokenWith: %v", err)
+	}
+	go verifier.Verify(foreign)
+	time.Sleep(20 * time.Millisecond)
+
+	verified := make(chan error, 1)
+	go func() {
+		_, err := verifier.Verify(token)
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Fatalf("Verify cached kid during refresh: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Verify of a cached kid blocked on the refresh")
+	}
+}
+
+func TestJWTAuthJWKSSkipsUnloadableKeys(t *testing.T) {
+	auth := newJWTTestAuth(t)
+	auth.Logger = slog.New(slog.DiscardHandler)
+	auth.PublicKeyLister = func() ([]string, error) {
+		return []string{"missing-kid"}, nil
+	}
+	set, err := auth.JWKS()
+	if err != nil {
+		t.Fatalf("JWKS: %v", err)
+	}
+	if len(set.Keys) != 1 || set.Keys[0].Kid == "missing-kid" {
+		t.Fatalf("keys = %#v", set.Keys)
+	}
+}
+
+func TestJWKSHandlerServesJSON(t *testing.T) {
+	auth := newJWTTestAuth(t)
+	rec := httptest.NewRecorder()
+	auth.JWKSHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status = %v, content-type = %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var set JWKS
+	if err := json.Unmarshal(rec.Body.Bytes(), &set); err != nil {
+		t.Fatalf("unmarshal jwks: %v", err)
+	}
+	if len(set.Keys) != 1 {
+		t.Fatalf("keys = %#v", set.Keys)
+	}
+}
@@ -1,12 +1,13 @@
 package authu
 
 import (
+	"context"
 	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 
 	"strings"
 	"sync"
@@ -14,6 +15,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jptrs93/goutil/timeu"
 
 	"github.com/golang-jwt/jwt/v4"
 )
@@ -21,38 +23,83 @@ import (
 const csrfKey = "csrf"
 const kidKey = "kid"
 
+var ErrCSRFMismatch = errors.New("csrf token mismatch")
+var ErrHMACWithoutSigningKeyStore = errors.New("hmac signing methods require a SigningKeyStore")
+
 type activeKey struct {
 	Created    time.Time
+	Loaded     time.Time
 	Kid        string
-	PrivateKey *rsa.PrivateKey
-	PublicKey  *rsa.PublicKey
+	SigningKey any
+	VerifyKey  any
 }
 
 type JWTAuth[T any, K any] struct {
 	AutoRotateDuration time.Duration
-	SigningMethod      jwt.SigningMethod
-	RSAKeySize         int
+	// SigningMethod selects the key algorithm generated on rotation. RS*, PS*, ES256,
+	// ES384, ES512, EdDSA and HS* are supported. HS* secrets are never passed to
+	// PublicKeyStorer, so HS* requires a SigningKeyStore, and instances only verify
+	// tokens signed with the secret they loaded from it.
+	SigningMethod jwt.SigningMethod
+	// RSAKeySize is only used by the RS* and PS* signing methods.
+	RSAKeySize int
 
 	activeKey atomic.Pointer[activeKey]
 
 	PublicKeyStorer func(kid string, key []byte) error
 	PublicKeyLoader func(kid string) ([]byte, error)
+	// PublicKeyLister optionally lists the kids of all stored public keys so that JWKS
+	// can publish keys minted by other instances. When nil only keys known to this
+	// instance are published.
+	PublicKeyLister func() ([]string, error)
 	UserLoader      func(sub K) (T, error)
 	mu              sync.Mutex
 
+	// SigningKeyStore optionally persists the encrypted private key so that all
+	// instances share one signing key and it survives restarts. KeyEncryptionKey must
+	// then hold a 32 byte AES-256 key, and PublicKeyStorer must accept a kid being
+	// stored again, as every instance publishes the key it loads.
+	SigningKeyStore  SigningKeyStore
+	KeyEncryptionKey []byte
+	// SigningKeyRefresh is how often the active key is re-read from SigningKeyStore.
+	SigningKeyRefresh time.Duration
+
+	// RevocationStore optionally enables Revoke and RevokeSubjectTokensBefore, and is
+	// consulted by every Verify.
+	RevocationStore RevocationStore
+
+	// RetiredKeyGrace is how long a rotated key keeps being published in the JWKS
+	// after it stopped being the active signing key.
+	RetiredKeyGrace time.Duration
+
 	CachedPublicKeys sync.Map
+
+	// Logger reports errors that don't fail the call, slog.Default() when nil.
+	Logger *slog.Logger
+
+	claims claimsConfig
 }
 
-func NewJWTAuth[T any, K any](storer func(string, []byte) error, loader func(string) ([]byte, error), userLoader func(K) (T, error)) *JWTAuth[T, K] {
+func NewJWTAuth[T any, K any](storer func(string, []byte) error, loader func(string) ([]byte, error), userLoader func(K) (T, error), opts ...ClaimsOption) *JWTAuth[T, K] {
 	return &JWTAuth[T, K]{
 		AutoRotateDuration: time.Hour * 24 * 90,
 		RSAKeySize:         2048,
 		SigningMethod:      jwt.SigningMethodRS256,
+		RetiredKeyGrace:    timeu.Month,
+		SigningKeyRefresh:  time.Minute,
 		PublicKeyStorer:    storer,
 		PublicKeyLoader:    loader,
 		UserLoader:         userLoader,
 		CachedPublicKeys:   sync.Map{},
+		claims:             claimsConfig{}.with(opts),
+	}
+}
+
+func (a *JWTAuth[T, K]) logger() *slog.Logger {
+	if a.Logger != nil {
+		return a.Logger
 	}
+	return slog.Default()
 }
 
 func encodeJWTSubject[K any](sub K) (string, error) {
@@ -82,23 +129,30 @@ func (a *JWTAuth[T, K]) GenerateTokenWith(sub K, scopes []string, ttl time.Durat
 	if err != nil {
 		return "", err
 	}
+	now := a.claims.now()
 	claims := jwt.MapClaims{
 		"sub":    encodedSub,
 		"scopes": scopes,
-		"exp":    time.Now().Add(ttl).Unix(),
-		"iat":    time.Now().Unix(),
+		"exp":    now.Add(ttl).Unix(),
+		"iat":    now.Unix(),
+		"nbf":    now.Unix(),
 	}
 	return a.Sign(claims)
 }
 
+// Sign signs the claims with the active key, filling in the configured iss and aud
+// and a jti and iat when absent.
 func (a *JWTAuth[T, K]) Sign(claims jwt.MapClaims) (string, error) {
+	if err := a.claims.applyStandardClaims(claims); err != nil {
+		return "", err
+	}
 	ak, err := a.ensureActiveKey()
 	if err != nil {
 		return "", err
 	}
 	token := jwt.NewWithClaims(a.SigningMethod, claims)
 	token.Header[kidKey] = ak.Kid
-	return token.SignedString(ak.PrivateKey)
+	return token.SignedString(ak.SigningKey)
 }
 
 func (a *JWTAuth[T, K]) SignDoubleSubmit(claims jwt.MapClaims) (string, string, error) {
@@ -111,8 +165,11 @@ func (a *JWTAuth[T, K]) SignDoubleSubmit(claims jwt.MapClaims) (string, string,
 	return jwtToken, csrfToken, err
 }
 
-func (a *JWTAuth[T, K]) Verify(jwtToken string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(jwtToken, a.loadKey)
+// Verify checks the token signature and its standard claims. Options override the
+// defaults given to NewJWTAuth for this call.
+func (a *JWTAuth[T, K]) Verify(jwtToken string, opts ...ClaimsOption) (jwt.MapClaims, error) {
+	// claims are validated below so that leeway and the typed errors apply
+	token, err := jwt.NewParser(jwt.WithoutClaimsValidation()).Parse(jwtToken, a.loadKey)
 	if err != nil {
 		return nil, err
 	}
@@ -120,11 +177,17 @@ func (a *JWTAuth[T, K]) Verify(jwtToken string) (jwt.MapClaims, error) {
 	if !ok || !token.Valid {
 		return nil, fmt.Errorf("invalid token")
 	}
+	if err := a.claims.with(opts).validate(claims); err != nil {
+		return nil, err
+	}
+	if err := a.checkRevoked(context.Background(), claims); err != nil {
+		return nil, err
+	}
 	return claims, nil
 }
 
-func (a *JWTAuth[T, K]) VerifyAndResolveUser(jwtToken string) (jwt.MapClaims, T, error) {
-	claims, err := a.Verify(jwtToken)
+func (a *JWTAuth[T, K]) VerifyAndResolveUser(jwtToken string, opts ...ClaimsOption) (jwt.MapClaims, T, error) {
+	claims, err := a.Verify(jwtToken, opts...)
 	if err != nil {
 		var zero T
 		return nil, zero, err
@@ -147,20 +210,20 @@ func (a *JWTAuth[T, K]) VerifyAndResolveUser(jwtToken string) (jwt.MapClaims, T,
 	return claims, user, nil
 }
 
-func (a *JWTAuth[T, K]) VerifyDoubleSubmit(jwtToken string, headerCsrf string) (jwt.MapClaims, error) {
-	claims, err := a.Verify(jwtToken)
+func (a *JWTAuth[T, K]) VerifyDoubleSubmit(jwtToken string, headerCsrf string, opts ...ClaimsOption) (jwt.MapClaims, error) {
+	claims, err := a.Verify(jwtToken, opts...)
 	if err != nil {
 		return nil, err
 	}
 	tokenCsrf, _ := claims[csrfKey].(string)
 	if strings.TrimSpace(tokenCsrf) == "" || tokenCsrf != headerCsrf {
-		return nil, fmt.Errorf("token csrf '%v' != header csrf '%v'", tokenCsrf, headerCsrf)
+		return nil, fmt.Errorf("%w: token csrf '%v' != header csrf '%v'", ErrCSRFMismatch, tokenCsrf, headerCsrf)
 	}
 	return claims, nil
 }
 
-func (a *JWTAuth[T, K]) VerifyDoubleSubmitAndResolveUser(jwtToken string, headerCsrf string) (jwt.MapClaims, T, error) {
-	claims, err := a.VerifyDoubleSubmit(jwtToken, headerCsrf)
+func (a *JWTAuth[T, K]) VerifyDoubleSubmitAndResolveUser(jwtToken string, headerCsrf string, opts ...ClaimsOption) (jwt.MapClaims, T, error) {
+	claims, err := a.VerifyDoubleSubmit(jwtToken, headerCsrf, opts...)
 	if err != nil {
 		var zero T
 		return nil, zero, err
@@ -192,36 +255,54 @@ func (a *JWTAuth[T, K]) loadKey(t *jwt.Token) (any, error) {
 	if !ok {
 		return nil, fmt.Errorf("bad kid header value")
 	}
+	verifyKey, err := a.publicKey(kid)
+	if err != nil {
+		return nil, err
+	}
+	if !keyMatchesAlg(t.Method.Alg(), verifyKey) {
+		return nil, fmt.Errorf("token alg '%v' not allowed for key '%v'", t.Method.Alg(), kid)
+	}
+	return verifyKey, nil
+}
+
+func (a *JWTAuth[T, K]) publicKey(kid string) (any, error) {
 	if ak := a.activeKey.Load(); ak != nil && kid == ak.Kid {
-		return ak.PublicKey, nil
+		return ak.VerifyKey, nil
+	}
+	if verifyKey, ok := a.CachedPublicKeys.Load(kid); ok && verifyKey != nil {
+		return verifyKey, nil
 	}
-	if kAny, ok := a.CachedPublicKeys.Load(kid); ok && kAny != nil {
-		if verifyKey, ok := kAny.(*rsa.PublicKey); ok {
-			return verifyKey, nil
+	if a.SigningKeyStore != nil {
+		// the kid may be the shared active key, which isn't loaded yet, and for HS*
+		// the store is the only place the secret can come from
+		if ak, err := a.ensureActiveKey(); err == nil && ak.Kid == kid {
+			return ak.VerifyKey, nil
 		}
 	}
 	a.mu.Lock()
 	defer a.mu.Unlock()
 	// repeat cache check incase another caller raced us
-	if kAny, ok := a.CachedPublicKeys.Load(kid); ok && kAny != nil {
-		if verifyKey, ok := kAny.(*rsa.PublicKey); ok {
-			return verifyKey, nil
-		}
+	if verifyKey, ok := a.CachedPublicKeys.Load(kid); ok && verifyKey != nil {
+		return verifyKey, nil
 	}
 	b, err := a.PublicKeyLoader(kid)
 	if err != nil {
 		return nil, fmt.Errorf("loading verify key: %w", err)
 	}
-	verifyKey, err := x509.ParsePKCS1PublicKey(b)
+	verifyKey, err := parseVerifyKey(b)
 	if err != nil {
-		return nil, fmt.Errorf("parsing public key: %w", err)
+		return nil, err
 	}
 	a.CachedPublicKeys.Store(kid, verifyKey)
 	return verifyKey, nil
 }
 
 func (a *JWTAuth[T, K]) isActiveKeyValid(ak *activeKey) bool {
-	return ak != nil && ak.PrivateKey != nil && ak.PublicKey != nil && strings.TrimSpace(ak.Kid) != "" && (a.AutoRotateDuration <= 0 || time.Since(ak.Created) < a.AutoRotateDuration)
+	if a.SigningKeyStore != nil && (ak == nil || time.Since(ak.Loaded) > a.SigningKeyRefresh) {
+		// periodically re-read the shared store so keys rotated by other instances are picked up
+		return false
+	}
+	return ak != nil && ak.SigningKey != nil && ak.VerifyKey != nil && strings.TrimSpace(ak.Kid) != "" && (a.AutoRotateDuration <= 0 || time.Since(ak.Created) < a.AutoRotateDuration)
 }
 
 func (a *JWTAuth[T, K]) ensureActiveKey() (*activeKey, error) {
@@ -237,32 +318,73 @@ func (a *JWTAuth[T, K]) ensureActiveKey() (*activeKey, error) {
 		return ak, nil
 	}
 
-	privateKey, err := rsa.GenerateKey(rand.Reader, a.RSAKeySize)
+	if _, ok := a.SigningMethod.(*jwt.SigningMethodHMAC); ok && a.SigningKeyStore == nil {
+		return nil, ErrHMACWithoutSigningKeyStore
+	}
+
+	if a.SigningKeyStore != nil {
+		return a.ensureSharedActiveKey()
+	}
+
+	ak, err := a.newActiveKey()
 	if err != nil {
-		return nil, fmt.Errorf("generating rsa key: %w", err)
+		return nil, err
 	}
+	a.activeKey.Store(ak)
+	return ak, nil
+}
 
-	kid, err := uuid.NewV7()
+// newActiveKey generates a key for the configured SigningMethod and stores its public
+// half. It does not make the key active.
+func (a *JWTAuth[T, K]) newActiveKey() (*activeKey, error) {
+	ak, err := a.generateActiveKey()
 	if err != nil {
-		return nil, fmt.Errorf("generating kid: %w", err)
+		return nil, err
 	}
+	if err := a.publishActiveKey(ak); err != nil {
+		return nil, err
+	}
+	return ak, nil
+}
 
-	publicKey := &privateKey.PublicKey
-	kidStr := kid.String()
-	if err := a.PublicKeyStorer(kidStr, x509.MarshalPKCS1PublicKey(publicKey)); err != nil {
-		return nil, fmt.Errorf("storing public key: %w", err)
+// generateActiveKey generates a key for the configured SigningMethod without storing
+// its public half.
+func (a *JWTAuth[T, K]) generateActiveKey() (*activeKey, error) {
+	signingKey, verifyKey, err := generateSigningKey(a.SigningMethod, a.RSAKeySize)
+	if err != nil {
+		return nil, err
 	}
 
-	ak := &activeKey{
-		Created:    time.Now(),
-		Kid:        kidStr,
-		PrivateKey: privateKey,
-		PublicKey:  publicKey,
+	kid, err := uuid.NewV7()
+	if err != nil {
+		return nil, fmt.Errorf("generating kid: %w", err)
 	}
-	a.activeKey.Store(ak)
-	a.CachedPublicKeys.Store(kidStr, publicKey)
 
-	return ak, nil
+	now := time.Now()
+	return &activeKey{
+		Created:    now,
+		Loaded:     now,
+		Kid:        kid.String(),
+		SigningKey: signingKey,
+		VerifyKey:  verifyKey,
+	}, nil
+}
+
+func (a *JWTAuth[T, K]) publishActiveKey(ak *activeKey) error {
+	if _, ok := ak.VerifyKey.([]byte); ok {
+		// hmac secrets stay in the SigningKeyStore
+		a.CachedPublicKeys.Store(ak.Kid, ak.VerifyKey)
+		return nil
+	}
+	encoded, err := marshalVerifyKey(ak.VerifyKey)
+	if err != nil {
+		return err
+	}
+	if err := a.PublicKeyStorer(ak.Kid, encoded); err != nil {
+		return fmt.Errorf("storing public key: %w", err)
+	}
+	a.CachedPublicKeys.Store(ak.Kid, ak.VerifyKey)
+	return nil
 }
 
 func GenerateRandomToken(n int) (string, error) {
@@ -0,0 +1,163 @@
+package authu
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// hmacKeyPrefix tags HMAC secrets in a SigningKeyStore so they can never be mistaken
+// for an encoded private key.
+var hmacKeyPrefix = []byte("hmac:")
+
+// generateSigningKey creates a new key pair for the signing method. For HMAC methods
+// the signing and verify keys are the same shared secret.
+func generateSigningKey(method jwt.SigningMethod, rsaKeySize int) (signingKey any, verifyKey any, err error) {
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		if rsaKeySize <= 0 {
+			rsaKeySize = 2048
+		}
+		privateKey, err := rsa.GenerateKey(rand.Reader, rsaKeySize)
+		if err != nil {
+			return nil, nil, fmt.Errorf("generating rsa key: %w", err)
+		}
+		return privateKey, &privateKey.PublicKey, nil
+	case *jwt.SigningMethodECDSA:
+		curve, err := ecdsaCurve(m.Alg())
+		if err != nil {
+			return nil, nil, err
+		}
+		privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("generating ecdsa key: %w", err)
+		}
+		return privateKey, &privateKey.PublicKey, nil
+	case *jwt.SigningMethodEd25519:
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("generating ed25519 key: %w", err)
+		}
+		return privateKey, publicKey, nil
+	case *jwt.SigningMethodHMAC:
+		secret := make([]byte, m.Hash.Size())
+		if _, err := rand.Read(secret); err != nil {
+			return nil, nil, fmt.Errorf("generating hmac secret: %w", err)
+		}
+		return secret, secret, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported signing method '%v'", method.Alg())
+	}
+}
+
+// marshalVerifyKey encodes a public key for PublicKeyStorer as PKIX. HMAC secrets are
+// refused, as they must never reach public key storage.
+func marshalVerifyKey(key any) ([]byte, error) {
+	if _, ok := key.([]byte); ok {
+		return nil, fmt.Errorf("hmac secrets can't be stored as public keys")
+	}
+	b, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling public key: %w", err)
+	}
+	return b, nil
+}
+
+// parseVerifyKey decodes keys written by marshalVerifyKey. Legacy PKCS1 encoded RSA
+// keys are still accepted, HMAC secrets written by earlier versions are not.
+func parseVerifyKey(b []byte) (any, error) {
+	if bytes.HasPrefix(b, hmacKeyPrefix) {
+		return nil, fmt.Errorf("hmac secrets are not loaded from public key storage")
+	}
+	if key, err := x509.ParsePKIXPublicKey(b); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS1PublicKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key: %w", err)
+	}
+	return key, nil
+}
+
+// keyMatchesAlg reports whether a token signed with alg may be verified with key.
+// Binding algorithms to key types is what stops algorithm confusion, e.g. an HS256
+// token "signed" with a published RSA public key.
+func keyMatchesAlg(alg string, key any) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg {
+		case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+			return true
+		}
+	case *ecdsa.PublicKey:
+		curve, err := ecdsaCurve(alg)
+		return err == nil && k.Curve == curve
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	case []byte:
+		switch alg {
+		case "HS256", "HS384", "HS512":
+			return true
+		}
+	}
+	return false
+}
+
+func ecdsaCurve(alg string) (elliptic.Curve, error) {
+	switch alg {
+	case "ES256":
+		return elliptic.P256(), nil
+	case "ES384":
+		return elliptic.P384(), nil
+	case "ES512":
+		return elliptic.P521(), nil
+	default:
+		return nil, fmt.Errorf("unsupported ecdsa alg '%v'", alg)
+	}
+}
+
+// marshalSigningKey encodes a signing key as PKCS8, or the tagged raw secret for HMAC
+// keys, so it can be encrypted and persisted in a SigningKeyStore.
+func marshalSigningKey(key any) ([]byte, error) {
+	if secret, ok := key.([]byte); ok {
+		return append(bytes.Clone(hmacKeyPrefix), secret...), nil
+	}
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling private key: %w", err)
+	}
+	return b, nil
+}
+
+// parseSigningKey decodes keys written by marshalSigningKey and returns the matching
+// verify key alongside.
+func parseSigningKey(b []byte) (signingKey any, verifyKey any, err error) {
+	if secret, ok := bytes.CutPrefix(b, hmacKeyPrefix); ok {
+		if len(secret) == 0 {
+			return nil, nil, fmt.Errorf("empty hmac secret")
+		}
+		secret = bytes.Clone(secret)
+		return secret, secret, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing private key: %w", err)
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, &k.PublicKey, nil
+	case *ecdsa.PrivateKey:
+		return k, &k.PublicKey, nil
+	case ed25519.PrivateKey:
+		return k, k.Public().(ed25519.PublicKey), nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
@@ -0,0 +1,152 @@
+package authu
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestJWTAuthSigningMethods(t *testing.T) {
+	methods := []jwt.SigningMethod{
+		jwt.SigningMethodRS256,
+		jwt.SigningMethodES256,
+		jwt.SigningMethodES384,
+		jwt.SigningMethodEdDSA,
+		jwt.SigningMethodHS256,
+	}
+	for _, method := range methods {
+		t.Run(method.Alg(), func(t *testing.T) {
+			auth := newJWTTestAuth(t)
+			auth.SigningMethod = method
+			if _, ok := method.(*jwt.SigningMethodHMAC); ok {
+				store, err := NewFileSigningKeyStore(t.TempDir())
+				if err != nil {
+					t.Fatalf("NewFileSigningKeyStore: %v", err)
+				}
+				auth.SigningKeyStore = store
+				auth.KeyEncryptionKey = bytes.Repeat([]byte("k"), 32)
+			}
+			token, err := auth.GenerateTokenWith("user-1", nil, time.Minute)
+			if err != nil {
+				t.Fatalf("GenerateTokenWith: %v", err)
+			}
+
+			// verify through a fresh instance so the key goes through storage and parsing
+			verifier := newJWTTestAuth(t)
+			verifier.SigningMethod = method
+			verifier.PublicKeyLoader = auth.PublicKeyLoader
+			verifier.SigningKeyStore = auth.SigningKeyStore
+			verifier.KeyEncryptionKey = auth.KeyEncryptionKey
+			claims, err := verifier.Verify(token)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if claims["sub"] != "user-1" {
+				t.Fatalf("sub = %v", claims["sub"])
+			}
+
+			set, err := auth.JWKS()
+			if err != nil {
+				t.Fatalf("JWKS: %v", err)
+			}
+			if method == jwt.SigningMethodHS256 {
+				if len(set.Keys) != 0 {
+					t.Fatalf("hmac secret published: %#v", set.Keys)
+				}
+				return
+			}
+			if len(set.Keys) != 1 || set.Keys[0].Alg != method.Alg() {
+				t.Fatalf("keys = %#v", set.Keys)
+			}
+			publicKey, err := set.Keys[0].PublicKey()
+			if err != nil {
+				t.Fatalf("PublicKey: %v", err)
+			}
+			if !keyMatchesAlg(method.Alg(), publicKey) {
+				t.Fatalf("jwk key %T does not match alg %v", publicKey, method.Alg())
+			}
+		})
+	}
+}
+
+func TestJWTAuthNeverStoresHMACSecretsAsPublicKeys(t *testing.T) {
+	auth := newJWTTestAuth(t)
+	auth.SigningMethod = jwt.SigningMethodHS256
+	stored := 0
+	auth.PublicKeyStorer = func(kid string, key []byte) error {
+		stored++
+		return nil
+	}
+	if _, err := auth.GenerateTokenWith("user-1", nil, time.Minute); !errors.Is(err, ErrHMACWithoutSigningKeyStore) {
+		t.Fatalf("GenerateTokenWith without SigningKeyStore err = %v", err)
+	}
+
+	store, err := NewFileSigningKeyStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileSigningKeyStore: %v", err)
+	}
+	auth.SigningKeyStore = store
+	auth.KeyEncryptionKey = bytes.Repeat([]byte("k"), 32)
+	if _, err := auth.GenerateTokenWith("user-1", nil, time.Minute); err != nil {
+		t.Fatalf("GenerateTokenWith: %v", err)
+	}
+	if stored != 0 {
+		t.Fatalf("hmac secret passed to PublicKeyStorer %d times", stored)
+	}
+	if _, err := parseVerifyKey([]byte("hmac:secret")); err == nil {
+		t.Fatalf("expected an hmac secret from public key storage to be refused")
+	}
+}
+
+func TestJWTAuthRejectsAlgorithmConfusion(t *testing.T) {
+	auth := newJWTTestAuth(t)
+	ak, err := auth.ensureActiveKey()
+	if err != nil {
+		t.Fatalf("ensureActiveKey: %v", err)
+	}
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(ak.VerifyKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+
+	// an HS256 token keyed with the (public) RSA key must not verify
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "admin", "exp": time.Now().Add(time.Minute).Unix()})
+	forged.Header[kidKey] = ak.Kid
+	signed, err := forged.SignedString(publicKeyBytes)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	if _, err := auth.Verify(signed); err == nil {
+		t.Fatalf("expected forged HS256 token to be rejected")
+	}
+
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"sub": "admin"})
+	none.Header[kidKey] = ak.Kid
+	signed, err = none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	if _, err := auth.Verify(signed); err == nil {
+		t.Fatalf("expected alg none token to be rejected")
+	}
+}
+
+func TestParseVerifyKeyAcceptsLegacyPKCS1(t *testing.T) {
+	auth := newJWTTestAuth(t)
+	ak, err := auth.ensureActiveKey()
+	if err != nil {
+		t.Fatalf("ensureActiveKey: %v", err)
+	}
+	key, err := parseVerifyKey(x509.MarshalPKCS1PublicKey(ak.VerifyKey.(*rsa.PublicKey)))
+	if err != nil {
+		t.Fatalf("parseVerifyKey: %v", err)
+	}
+	if !key.(*rsa.PublicKey).Equal(ak.VerifyKey) {
+		t.Fatalf("parsed key does not match")
+	}
+}
@@ -0,0 +1,144 @@
+package authu
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+type authContextKey int
+
+const (
+	userContextKey authContextKey = iota
+	claimsContextKey
+)
+
+// AuthMiddleware authenticates requests with JWTAuth double-submit tokens. The JWT is
+// read from an HttpOnly cookie, or from an Authorization bearer header, and the CSRF
+// token from a request header. Browsers never attach the Authorization header on their
+// own, so bearer tokens and safe methods skip the CSRF check.
+type AuthMiddleware[T any, K any] struct {
+	Auth           *JWTAuth[T, K]
+	CookieName     string
+	CSRFCookieName string
+	CSRFHeader     string
+	CookiePath     string
+	CookieDomain   string
+	SameSite       http.SameSite
+	// Insecure drops the Secure cookie attribute, only for local plain http development.
+	Insecure bool
+	// ClaimsOptions are passed to every Verify call.
+	ClaimsOptions []ClaimsOption
+}
+
+func NewAuthMiddleware[T any, K any](auth *JWTAuth[T, K]) *AuthMiddleware[T, K] {
+	return &AuthMiddleware[T, K]{
+		Auth:           auth,
+		CookieName:     "auth",
+		CSRFCookieName: "csrf",
+		CSRFHeader:     "X-CSRF-Token",
+		CookiePath:     "/",
+		SameSite:       http.SameSiteLaxMode,
+	}
+}
+
+// Handler rejects unauthenticated requests with 401 and CSRF failures with 403,
+// otherwise it serves next with the user and claims in the request context.
+func (m *AuthMiddleware[T, K]) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, user, err := m.authenticate(r)
+		if err != nil {
+			if errors.Is(err, ErrCSRFMismatch) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ContextWithAuth(r.Context(), user, claims)))
+	})
+}
+
+func (m *AuthMiddleware[T, K]) authenticate(r *http.Request) (jwt.MapClaims, T, error) {
+	if bearer, ok := bearerToken(r); ok {
+		return m.Auth.VerifyAndResolveUser(bearer, m.ClaimsOptions...)
+	}
+	cookie, err := r.Cookie(m.CookieName)
+	if err != nil || cookie.Value == "" {
+		var zero T
+		return nil, zero, errors.New("missing auth token")
+	}
+	if isSafeMethod(r.Method) {
+		return m.Auth.VerifyAndResolveUser(cookie.Value, m.ClaimsOptions...)
+	}
+	return m.Auth.VerifyDoubleSubmitAndResolveUser(cookie.Value, r.Header.Get(m.CSRFHeader), m.ClaimsOptions...)
+}
+
+// SetAuthCookies stores the tokens returned by SignDoubleSubmit. The JWT cookie is
+// HttpOnly; the CSRF cookie is readable by scripts so they can echo it in CSRFHeader.
+func (m *AuthMiddleware[T, K]) SetAuthCookies(w http.ResponseWriter, jwtToken string, csrfToken string, expires time.Time) {
+	http.SetCookie(w, m.cookie(m.CookieName, jwtToken, expires, true))
+	http.SetCookie(w, m.cookie(m.CSRFCookieName, csrfToken, expires, false))
+}
+
+func (m *AuthMiddleware[T, K]) ClearAuthCookies(w http.ResponseWriter) {
+	for _, c := range []*http.Cookie{m.cookie(m.CookieName, "", time.Time{}, true), m.cookie(m.CSRFCookieName, "", time.Time{}, false)} {
+		c.MaxAge = -1
+		http.SetCookie(w, c)
+	}
+}
+
+func (m *AuthMiddleware[T, K]) cookie(name string, value string, expires time.Time, httpOnly bool) *http.Cookie {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     m.CookiePath,
+		Domain:   m.CookieDomain,
+		Expires:  expires,
+		Secure:   !m.Insecure,
+		HttpOnly: httpOnly,
+		SameSite: m.SameSite,
+	}
+	if !expires.IsZero() {
+		c.MaxAge = max(int(time.Until(expires).Seconds()), 1)
+	}
+	return c
+}
+
+// ContextWithAuth stores the authenticated user and claims, as AuthMiddleware does.
+func ContextWithAuth[T any](ctx context.Context, user T, claims jwt.MapClaims) context.Context {
+	ctx = context.WithValue(ctx, userContextKey, user)
+	return context.WithValue(ctx, claimsContextKey, claims)
+}
+
+func UserFromContext[T any](ctx context.Context) (T, bool) {
+	user, ok := ctx.Value(userContextKey).(T)
+	return user, ok
+}
+
+func ClaimsFromContext(ctx context.Context) (jwt.MapClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(jwt.MapClaims)
+	return claims, ok
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
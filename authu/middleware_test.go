@@ -0,0 +1,80 @@
+package authu
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	auth := newJWTTestAuth(t)
+	middleware := NewAuthMiddleware(auth)
+	handler := middleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := UserFromContext[string](r.Context())
+		claims, claimsOK := ClaimsFromContext(r.Context())
+		if !ok || !claimsOK || claims["sub"] != user {
+			t.Errorf("context user = %q, claims = %v", user, claims)
+		}
+		_, _ = w.Write([]byte(user))
+	}))
+
+	jwtToken, csrfToken, err := auth.SignDoubleSubmit(jwt.MapClaims{"sub": "user-1", "exp": time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatalf("SignDoubleSubmit: %v", err)
+	}
+	login := httptest.NewRecorder()
+	middleware.SetAuthCookies(login, jwtToken, csrfToken, time.Now().Add(time.Minute))
+	cookies := login.Result().Cookies()
+	if len(cookies) != 2 || !cookies[0].HttpOnly || !cookies[0].Secure || cookies[1].HttpOnly {
+		t.Fatalf("cookies = %#v", cookies)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		csrf   string
+		bearer string
+		cookie bool
+		want   int
+	}{
+		{name: "no token", method: http.MethodGet, want: http.StatusUnauthorized},
+		{name: "safe method skips csrf", method: http.MethodGet, cookie: true, want: http.StatusOK},
+		{name: "unsafe method with csrf", method: http.MethodPost, cookie: true, csrf: csrfToken, want: http.StatusOK},
+		{name: "unsafe method without csrf", method: http.MethodPost, cookie: true, want: http.StatusForbidden},
+		{name: "unsafe method wrong csrf", method: http.MethodPost, cookie: true, csrf: "nope", want: http.StatusForbidden},
+		{name: "bearer token", method: http.MethodPost, bearer: jwtToken, want: http.StatusOK},
+		{name: "bad bearer token", method: http.MethodGet, bearer: "garbage", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			if tt.cookie {
+				for _, c := range cookies {
+					req.AddCookie(c)
+				}
+			}
+			if tt.csrf != "" {
+				req.Header.Set(middleware.CSRFHeader, tt.csrf)
+			}
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %v, want %v", rec.Code, tt.want)
+			}
+		})
+	}
+
+	logout := httptest.NewRecorder()
+	middleware.ClearAuthCookies(logout)
+	for _, c := range logout.Result().Cookies() {
+		if c.MaxAge >= 0 || c.Value != "" {
+			t.Fatalf("cookie not cleared: %#v", c)
+		}
+	}
+}
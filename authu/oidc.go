@@ -0,0 +1,307 @@
+package authu
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var ErrOIDCStateMismatch = errors.New("oidc state mismatch")
+var ErrOIDCNonceMismatch = errors.New("oidc nonce mismatch")
+
+// OIDCProviderMetadata is the subset of the discovery document the client uses.
+type OIDCProviderMetadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint,omitempty"`
+	JWKSURI               string   `json:"jwks_uri"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported,omitempty"`
+}
+
+// OIDCIdentity is the verified identity from an ID token, keyed by Issuer and Subject.
+// Email should only be trusted for account linking when EmailVerified is set.
+type OIDCIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Claims        jwt.MapClaims
+}
+
+// OIDCAuthRequest is the per-login state created by AuthCodeURL. It must be kept, e.g.
+// in a short-lived signed cookie, until the provider redirects back to RedirectURL.
+type OIDCAuthRequest struct {
+	URL          string `json:"-"`
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+type OIDCTokens struct {
+	AccessToken  string
+	RefreshToken string
+	IDToken      string
+	TokenType    string
+	Expiry       time.Time
+}
+
+// OIDCClient is an OpenID Connect relying party using the authorization code flow with
+// PKCE. Verified identities are resolved to users through UserLoader, as JWTAuth does
+// for its own tokens.
+type OIDCClient[T any] struct {
+	ClientID string
+	// ClientSecret is sent with client_secret_basic. Leave empty for public clients.
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	Provider     OIDCProviderMetadata
+	HTTPClient   *http.Client
+	UserLoader   func(identity OIDCIdentity) (T, error)
+
+	verifier *JWKSVerifier
+}
+
+// DiscoverOIDC loads the provider's discovery document from
+// <issuer>/.well-known/openid-configuration.
+func DiscoverOIDC[T any](ctx context.Context, issuer string, clientID string, clientSecret string, redirectURL string, userLoader func(OIDCIdentity) (T, error)) (*OIDCClient[T], error) {
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	var metadata OIDCProviderMetadata
+	if err := oidcGetJSON(ctx, httpClient, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", "", &metadata); err != nil {
+		return nil, fmt.Errorf("loading oidc discovery document: %w", err)
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, fmt.Errorf("%w: discovery document issuer '%v'", ErrWrongIssuer, metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("incomplete oidc discovery document")
+	}
+	if !metadata.supportsS256() {
+		return nil, fmt.Errorf("oidc provider does not support S256 PKCE")
+	}
+	client := NewOIDCClient(metadata, clientID, clientSecret, redirectURL, userLoader)
+	client.HTTPClient = httpClient
+	client.verifier.HTTPClient = httpClient
+	return client, nil
+}
+
+// NewOIDCClient creates a client from known provider metadata, skipping discovery.
+func NewOIDCClient[T any](provider OIDCProviderMetadata, clientID string, clientSecret string, redirectURL string, userLoader func(OIDCIdentity) (T, error)) *OIDCClient[T] {
+	return &OIDCClient[T]{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "profile", "email"},
+		Provider:     provider,
+		HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+		UserLoader:   userLoader,
+		verifier:     NewJWKSVerifier(provider.JWKSURI, WithIssuer(provider.Issuer), WithAudience(clientID), WithLeeway(time.Minute)),
+	}
+}
+
+// AuthCodeURL starts a login, returning the URL to redirect the user to along with the
+// state, nonce and PKCE verifier to keep for Exchange.
+func (c *OIDCClient[T]) AuthCodeURL() (OIDCAuthRequest, error) {
+	var req OIDCAuthRequest
+	var err error
+	if req.State, err = GenerateRandomToken(24); err != nil {
+		return req, err
+	}
+	if req.Nonce, err = GenerateRandomToken(24); err != nil {
+		return req, err
+	}
+	if req.CodeVerifier, err = GenerateRandomToken(32); err != nil {
+		return req, err
+	}
+	challenge := sha256.Sum256([]byte(req.CodeVerifier))
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", c.ClientID)
+	q.Set("redirect_uri", c.RedirectURL)
+	q.Set("scope", strings.Join(c.Scopes, " "))
+	q.Set("state", req.State)
+	q.Set("nonce", req.Nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(c.Provider.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	req.URL = c.Provider.AuthorizationEndpoint + sep + q.Encode()
+	return req, nil
+}
+
+// HandleCallback completes a login from the redirect back to RedirectURL: it checks the
+// state, exchanges the code and resolves the user from the verified ID token.
+func (c *OIDCClient[T]) HandleCallback(r *http.Request, authReq OIDCAuthRequest) (T, *OIDCTokens, error) {
+	var zero T
+	q := r.URL.Query()
+	if providerErr := q.Get("error"); providerErr != "" {
+		return zero, nil, fmt.Errorf("oidc provider error '%v': %v", providerErr, q.Get("error_description"))
+	}
+	tokens, identity, err := c.Exchange(r.Context(), authReq, q.Get("state"), q.Get("code"))
+	if err != nil {
+		return zero, nil, err
+	}
+	user, err := c.UserLoader(identity)
+	if err != nil {
+		return zero, nil, fmt.Errorf("resolving user: %w", err)
+	}
+	return user, tokens, nil
+}
+
+// Exchange checks state against the stored request, redeems code and verifies the
+// returned ID token including its nonce.
+func (c *OIDCClient[T]) Exchange(ctx context.Context, authReq OIDCAuthRequest, state string, code string) (*OIDCTokens, OIDCIdentity, error) {
+	if authReq.State == "" || subtle.ConstantTimeCompare([]byte(state), []byte(authReq.State)) != 1 {
+		return nil, OIDCIdentity{}, ErrOIDCStateMismatch
+	}
+	if code == "" {
+		return nil, OIDCIdentity{}, fmt.Errorf("missing authorization code")
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.RedirectURL)
+	form.Set("code_verifier", authReq.CodeVerifier)
+	tokens, err := c.tokenRequest(ctx, form)
+	if err != nil {
+		return nil, OIDCIdentity{}, err
+	}
+	identity, err := c.VerifyIDToken(tokens.IDToken, authReq.Nonce)
+	if err != nil {
+		return nil, OIDCIdentity{}, err
+	}
+	return tokens, identity, nil
+}
+
+// VerifyIDToken checks the ID token signature against the provider's cached JWKS, its
+// iss, aud, azp and expiry, and that it carries nonce.
+func (c *OIDCClient[T]) VerifyIDToken(idToken string, nonce string) (OIDCIdentity, error) {
+	if idToken == "" {
+		return OIDCIdentity{}, fmt.Errorf("%w 'id_token'", ErrMissingClaim)
+	}
+	claims, err := c.verifier.Verify(idToken)
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("verifying id token: %w", err)
+	}
+	if _, ok := claims["exp"]; !ok {
+		return OIDCIdentity{}, fmt.Errorf("%w 'exp'", ErrMissingClaim)
+	}
+	if tokenNonce, _ := claims["nonce"].(string); nonce == "" || subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return OIDCIdentity{}, ErrOIDCNonceMismatch
+	}
+	// with several audiences the token must have been issued to this client
+	if audiences := claimStrings(claims["aud"]); len(audiences) > 1 || claims["azp"] != nil {
+		if azp, _ := claims["azp"].(string); azp != c.ClientID {
+			return OIDCIdentity{}, fmt.Errorf("%w: azp '%v'", ErrWrongAudience, azp)
+		}
+	}
+	identity := OIDCIdentity{Claims: claims}
+	identity.Issuer, _ = claims["iss"].(string)
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	identity.Name, _ = claims["name"].(string)
+	if identity.Subject == "" {
+		return OIDCIdentity{}, fmt.Errorf("%w 'sub'", ErrMissingClaim)
+	}
+	return identity, nil
+}
+
+// UserInfo fetches the userinfo endpoint. Callers should check the returned sub
+// matches the ID token's before trusting it.
+func (c *OIDCClient[T]) UserInfo(ctx context.Context, accessToken string) (map[string]any, error) {
+	if c.Provider.UserinfoEndpoint == "" {
+		return nil, fmt.Errorf("oidc provider has no userinfo endpoint")
+	}
+	var info map[string]any
+	if err := oidcGetJSON(ctx, c.HTTPClient, c.Provider.UserinfoEndpoint, accessToken, &info); err != nil {
+		return nil, fmt.Errorf("fetching userinfo: %w", err)
+	}
+	return info, nil
+}
+
+func (c *OIDCClient[T]) tokenRequest(ctx context.Context, form url.Values) (*OIDCTokens, error) {
+	if c.ClientSecret == "" {
+		form.Set("client_id", c.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("creating token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting tokens: %w", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		RefreshToken     string `json:"refresh_token"`
+		IDToken          string `json:"id_token"`
+		TokenType        string `json:"token_type"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return nil, fmt.Errorf("token request failed with status %v: %v %v", resp.StatusCode, body.Error, body.ErrorDescription)
+	}
+	tokens := &OIDCTokens{
+		AccessToken:  body.AccessToken,
+		RefreshToken: body.RefreshToken,
+		IDToken:      body.IDToken,
+		TokenType:    body.TokenType,
+	}
+	if body.ExpiresIn > 0 {
+		tokens.Expiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+	return tokens, nil
+}
+
+func oidcGetJSON(ctx context.Context, client *http.Client, endpoint string, bearer string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %v", resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// supportsS256 reports whether the provider advertises S256 PKCE, assuming support
+// when the discovery document doesn't say.
+func (m OIDCProviderMetadata) supportsS256() bool {
+	return len(m.CodeChallengeMethods) == 0 || slices.Contains(m.CodeChallengeMethods, "S256")
+}
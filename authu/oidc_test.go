@@ -0,0 +1,171 @@
+package authu
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// oidcTestProvider is an in-process OIDC provider signing ID tokens with a JWTAuth and
+// serving its JWKS.
+type oidcTestProvider struct {
+	*httptest.Server
+	auth     *JWTAuth[string, string]
+	mu       sync.Mutex
+	codes    map[string]url.Values // code -> authorize request
+	idClaims jwt.MapClaims
+}
+
+func newOIDCTestProvider(t *testing.T) *oidcTestProvider {
+	t.Helper()
+	p := &oidcTestProvider{auth: newJWTTestAuth(t), codes: map[string]url.Values{}}
+	p.auth.SigningMethod = jwt.SigningMethodES256
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(OIDCProviderMetadata{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			UserinfoEndpoint:      p.URL + "/userinfo",
+			JWKSURI:               p.URL + "/jwks",
+			CodeChallengeMethods:  []string{"S256"},
+		})
+	})
+	mux.Handle("GET /jwks", p.auth.JWKSHandler())
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		p.mu.Lock()
+		authorize, ok := p.codes[r.FormValue("code")]
+		delete(p.codes, r.FormValue("code"))
+		p.mu.Unlock()
+		challenge := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || id != "client" || secret != "secret" || r.FormValue("redirect_uri") != authorize.Get("redirect_uri") ||
+			base64.RawURLEncoding.EncodeToString(challenge[:]) != authorize.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := jwt.MapClaims{
+			"iss":            p.URL,
+			"sub":            "provider-user-1",
+			"aud":            "client",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"nonce":          authorize.Get("nonce"),
+			"email":          "alice@example.com",
+			"email_verified": true,
+		}
+		for k, v := range p.idClaims {
+			claims[k] = v
+		}
+		idToken, err := p.auth.Sign(claims)
+		if err != nil {
+			t.Errorf("signing id token: %v", err)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "access-1", "token_type": "Bearer", "expires_in": 3600, "id_token": idToken})
+	})
+	mux.HandleFunc("GET /userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"sub": "provider-user-1", "name": "Alice"})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// authorize stands in for the user signing in at the provider, returning the callback
+// request the provider redirects back with.
+func (p *oidcTestProvider) authorize(t *testing.T, authURL string) *http.Request {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("response_type") != "code" {
+		t.Fatalf("authorize query = %v", q)
+	}
+	code, _ := GenerateRandomToken(16)
+	p.mu.Lock()
+	p.codes[code] = q
+	p.mu.Unlock()
+	return httptest.NewRequest(http.MethodGet, q.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), nil)
+}
+
+func newOIDCTestClient(t *testing.T, p *oidcTestProvider) *OIDCClient[string] {
+	t.Helper()
+	client, err := DiscoverOIDC(context.Background(), p.URL, "client", "secret", "https://app.example.com/callback", func(identity OIDCIdentity) (string, error) {
+		if !identity.EmailVerified {
+			return "", errors.New("unverified email")
+		}
+		return identity.Email, nil
+	})
+	if err != nil {
+		t.Fatalf("DiscoverOIDC: %v", err)
+	}
+	return client
+}
+
+func TestOIDCClientLogin(t *testing.T) {
+	p := newOIDCTestProvider(t)
+	client := newOIDCTestClient(t, p)
+	authReq, err := client.AuthCodeURL()
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	user, tokens, err := client.HandleCallback(p.authorize(t, authReq.URL), authReq)
+	if err != nil {
+		t.Fatalf("HandleCallback: %v", err)
+	}
+	if user != "alice@example.com" || tokens.AccessToken != "access-1" || tokens.Expiry.IsZero() {
+		t.Fatalf("HandleCallback = %v, %+v", user, tokens)
+	}
+	info, err := client.UserInfo(context.Background(), tokens.AccessToken)
+	if err != nil || info["name"] != "Alice" {
+		t.Fatalf("UserInfo = %v, %v", info, err)
+	}
+}
+
+func TestOIDCClientRejections(t *testing.T) {
+	p := newOIDCTestProvider(t)
+	client := newOIDCTestClient(t, p)
+
+	authReq, _ := client.AuthCodeURL()
+	callback := p.authorize(t, authReq.URL)
+	otherReq, _ := client.AuthCodeURL()
+	if _, _, err := client.HandleCallback(callback, otherReq); !errors.Is(err, ErrOIDCStateMismatch) {
+		t.Fatalf("state mismatch err = %v", err)
+	}
+
+	// a stored request with the right state but another nonce, as in a replayed token
+	authReq, _ = client.AuthCodeURL()
+	callback = p.authorize(t, authReq.URL)
+	authReq.Nonce = "other"
+	if _, _, err := client.HandleCallback(callback, authReq); !errors.Is(err, ErrOIDCNonceMismatch) {
+		t.Fatalf("nonce mismatch err = %v", err)
+	}
+
+	p.idClaims = jwt.MapClaims{"aud": "someone-else"}
+	authReq, _ = client.AuthCodeURL()
+	if _, _, err := client.HandleCallback(p.authorize(t, authReq.URL), authReq); !errors.Is(err, ErrWrongAudience) {
+		t.Fatalf("wrong audience err = %v", err)
+	}
+
+	p.idClaims = jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}
+	authReq, _ = client.AuthCodeURL()
+	if _, _, err := client.HandleCallback(p.authorize(t, authReq.URL), authReq); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("expired id token err = %v", err)
+	}
+}
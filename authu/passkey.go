@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/go-webauthn/webauthn/protocol"
@@ -23,27 +22,27 @@ var ErrUserMismatch = errors.New("passkey user mismatch")
 type WebAuthnID = []byte
 
 type PasskeyService[U gowebauthn.User] struct {
-	inner                *gowebauthn.WebAuthn
-	registrationSessions inMemorySessions
-	loginSessions        inMemorySessions
-	SaveCredential       func(userID WebAuthnID, credential *gowebauthn.Credential) error
-	LoadUser             func(userID WebAuthnID) (U, error)
-	LoadCredentialOwner  func(credentialID WebAuthnID) (user U, err error)
-	SessionTTL           time.Duration
+	inner *gowebauthn.WebAuthn
+	// Sessions holds in-flight ceremonies between their Begin and Finish calls. Use a
+	// shared store when a ceremony may finish on a different replica than it started.
+	Sessions            PasskeySessionStore
+	SaveCredential      func(userID WebAuthnID, credential *gowebauthn.Credential) error
+	LoadUser            func(userID WebAuthnID) (U, error)
+	LoadCredentialOwner func(credentialID WebAuthnID) (user U, err error)
+	// LoadUserByName is only needed for username-first login.
+	LoadUserByName func(username string) (U, error)
+	// Credentials optionally enables the credential management APIs and last used tracking.
+	Credentials PasskeyCredentialStore
+	// AllowCloneWarning lets logins succeed even when the authenticator's sign count
+	// suggests it was cloned. The warning is still recorded on the credential.
+	AllowCloneWarning bool
+	SessionTTL        time.Duration
 }
 
-type passkeySession struct {
-	SessionID string
-	UserID    []byte
-	HasUserID bool
-	ExpiresAt time.Time
-	Payload   []byte
-}
-
-type inMemorySessions struct {
-	mu      sync.Mutex
-	records map[string]passkeySession
-}
+const (
+	passkeyCeremonyRegistration = "registration"
+	passkeyCeremonyLogin        = "login"
+)
 
 func NewPasskeyService[U gowebauthn.User](config *gowebauthn.Config, saveCredential func(userID []byte, credential *gowebauthn.Credential) error, loadUser func(userID []byte) (U, error), loadCredentialOwner func(credentialID []byte) (user U, err error)) (*PasskeyService[U], error) {
 	if config == nil {
@@ -57,13 +56,12 @@ func NewPasskeyService[U gowebauthn.User](config *gowebauthn.Config, saveCredent
 		return nil, fmt.Errorf("creating webauthn config: %w", err)
 	}
 	return &PasskeyService[U]{
-		inner:                inner,
-		registrationSessions: inMemorySessions{records: map[string]passkeySession{}},
-		loginSessions:        inMemorySessions{records: map[string]passkeySession{}},
-		SaveCredential:       saveCredential,
-		LoadUser:             loadUser,
-		LoadCredentialOwner:  loadCredentialOwner,
-		SessionTTL:           5 * time.Minute,
+		inner:               inner,
+		Sessions:            NewInMemoryPasskeySessionStore(),
+		SaveCredential:      saveCredential,
+		LoadUser:            loadUser,
+		LoadCredentialOwner: loadCredentialOwner,
+		SessionTTL:          5 * time.Minute,
 	}, nil
 }
 
@@ -85,16 +83,18 @@ func (s *PasskeyService[U]) BeginRegistration(userID []byte) (string, []byte, er
 	if err != nil {
 		return "", nil, fmt.Errorf("marshal registration options: %w", err)
 	}
-	record, err := s.newSession(userID, true, session)
+	record, err := s.newSession(passkeyCeremonyRegistration, userID, true, session)
 	if err != nil {
 		return "", nil, err
 	}
-	s.registrationSessions.save(record)
+	if err := s.Sessions.Save(record); err != nil {
+		return "", nil, fmt.Errorf("save passkey session: %w", err)
+	}
 	return record.SessionID, optionsJSON, nil
 }
 
 func (s *PasskeyService[U]) FinishRegistration(userID []byte, sessionID string, credentialJSON []byte) ([]byte, error) {
-	record, err := s.consumeSession(&s.registrationSessions, sessionID)
+	record, err := s.consumeSession(passkeyCeremonyRegistration, sessionID)
 	if err != nil {
 		return nil, err
 	}
@@ -116,6 +116,9 @@ func (s *PasskeyService[U]) FinishRegistration(userID []byte, sessionID string,
 	if err := s.saveCredential(userID, credential); err != nil {
 		return nil, err
 	}
+	if err := s.recordCredentialRegistration(userID, credential); err != nil {
+		return nil, err
+	}
 	return credential.ID, nil
 }
 
@@ -128,17 +131,19 @@ func (s *PasskeyService[U]) BeginLogin() (string, []byte, error) {
 	if err != nil {
 		return "", nil, fmt.Errorf("marshal login options: %w", err)
 	}
-	record, err := s.newSession(nil, false, session)
+	record, err := s.newSession(passkeyCeremonyLogin, nil, false, session)
 	if err != nil {
 		return "", nil, err
 	}
-	s.loginSessions.save(record)
+	if err := s.Sessions.Save(record); err != nil {
+		return "", nil, fmt.Errorf("save passkey session: %w", err)
+	}
 	return record.SessionID, optionsJSON, nil
 }
 
 func (s *PasskeyService[U]) FinishLogin(sessionID string, credentialJSON []byte) (U, error) {
 	var zero U
-	record, err := s.consumeSession(&s.loginSessions, sessionID)
+	record, err := s.consumeSession(passkeyCeremonyLogin, sessionID)
 	if err != nil {
 		return zero, err
 	}
@@ -165,27 +170,28 @@ func (s *PasskeyService[U]) FinishLogin(sessionID string, credentialJSON []byte)
 	if !ok {
 		return zero, fmt.Errorf("unexpected passkey user type %T", validatedUser)
 	}
-	if err := s.saveCredential(resolvedUserID, credential); err != nil {
+	if err := s.recordCredentialUse(resolvedUserID, credential); err != nil {
 		return zero, err
 	}
 	return user, nil
 }
 
-func (s *PasskeyService[U]) newSession(userID []byte, hasUserID bool, session *gowebauthn.SessionData) (passkeySession, error) {
+func (s *PasskeyService[U]) newSession(ceremony string, userID []byte, hasUserID bool, session *gowebauthn.SessionData) (PasskeySession, error) {
 	sessionID, err := GenerateRandomToken(24)
 	if err != nil {
-		return passkeySession{}, fmt.Errorf("generate passkey session id: %w", err)
+		return PasskeySession{}, fmt.Errorf("generate passkey session id: %w", err)
 	}
 	payload, err := encodeSession(session)
 	if err != nil {
-		return passkeySession{}, err
+		return PasskeySession{}, err
 	}
 	expiresAt := session.Expires
 	if expiresAt.IsZero() {
 		expiresAt = time.Now().Add(s.sessionTTL())
 	}
-	return passkeySession{
+	return PasskeySession{
 		SessionID: sessionID,
+		Ceremony:  ceremony,
 		UserID:    bytes.Clone(userID),
 		HasUserID: hasUserID,
 		ExpiresAt: expiresAt,
@@ -193,12 +199,19 @@ func (s *PasskeyService[U]) newSession(userID []byte, hasUserID bool, session *g
 	}, nil
 }
 
-func (s *PasskeyService[U]) consumeSession(store *inMemorySessions, sessionID string) (*passkeySession, error) {
+func (s *PasskeyService[U]) consumeSession(ceremony string, sessionID string) (*PasskeySession, error) {
 	sessionID = strings.TrimSpace(sessionID)
 	if sessionID == "" {
 		return nil, ErrSessionInvalid
 	}
-	return store.consume(sessionID)
+	record, err := s.Sessions.Consume(ceremony, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if record.Ceremony != ceremony || time.Now().After(record.ExpiresAt) {
+		return nil, ErrSessionInvalid
+	}
+	return record, nil
 }
 
 func (s *PasskeyService[U]) saveCredential(userID []byte, credential *gowebauthn.Credential) error {
@@ -219,37 +232,6 @@ func (s *PasskeyService[U]) userVerification() protocol.UserVerificationRequirem
 	return s.inner.Config.AuthenticatorSelection.UserVerification
 }
 
-func (s *inMemorySessions) save(record passkeySession) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.deleteExpiredLocked(time.Now())
-	s.records[record.SessionID] = record
-}
-
-func (s *inMemorySessions) consume(sessionID string) (*passkeySession, error) {
-	now := time.Now()
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.deleteExpiredLocked(now)
-	record, ok := s.records[sessionID]
-	if !ok {
-		return nil, ErrSessionInvalid
-	}
-	delete(s.records, sessionID)
-	if now.After(record.ExpiresAt) {
-		return nil, ErrSessionInvalid
-	}
-	return &record, nil
-}
-
-func (s *inMemorySessions) deleteExpiredLocked(now time.Time) {
-	for sessionID, record := range s.records {
-		if now.After(record.ExpiresAt) {
-			delete(s.records, sessionID)
-		}
-	}
-}
-
 func encodeSession(session *gowebauthn.SessionData) ([]byte, error) {
 	if session == nil {
 		return nil, fmt.Errorf("nil passkey session")
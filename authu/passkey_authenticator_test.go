@@ -0,0 +1,276 @@
+package authu
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const passkeyTestOrigin = "https://example.com"
+
+// softwareAuthenticator is a minimal ES256 platform authenticator producing "none"
+// attestations, enough to drive full passkey ceremonies in tests.
+type softwareAuthenticator struct {
+	t            *testing.T
+	rpID         string
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftwareAuthenticator(t *testing.T, rpID string) *softwareAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating authenticator key: %v", err)
+	}
+	credentialID := make([]byte, 16)
+	_, _ = rand.Read(credentialID)
+	return &softwareAuthenticator{t: t, rpID: rpID, key: key, credentialID: credentialID}
+}
+
+func (a *softwareAuthenticator) register(optionsJSON []byte) []byte {
+	a.t.Helper()
+	var options struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+			User      struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(optionsJSON, &options); err != nil {
+		a.t.Fatalf("unmarshal creation options: %v", err)
+	}
+	userHandle, err := base64.RawURLEncoding.DecodeString(options.PublicKey.User.ID)
+	if err != nil {
+		a.t.Fatalf("decoding user handle: %v", err)
+	}
+	a.userHandle = userHandle
+
+	point, err := a.key.PublicKey.Bytes()
+	if err != nil {
+		a.t.Fatalf("encoding public key: %v", err)
+	}
+	coseKey, err := webauthncbor.Marshal(map[int]any{1: 2, 3: -7, -1: 1, -2: point[1:33], -3: point[33:]})
+	if err != nil {
+		a.t.Fatalf("encoding cose key: %v", err)
+	}
+	authData := a.authData(0x45) // UP | UV | AT
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	authData = append(authData, coseKey...)
+	attestation, err := webauthncbor.Marshal(map[string]any{"fmt": "none", "attStmt": map[string]any{}, "authData": authData})
+	if err != nil {
+		a.t.Fatalf("encoding attestation: %v", err)
+	}
+	return a.marshalResponse(map[string]any{
+		"clientDataJSON":    a.clientData("webauthn.create", options.PublicKey.Challenge),
+		"attestationObject": b64(attestation),
+		"transports":        []string{"internal"},
+	})
+}
+
+func (a *softwareAuthenticator) login(optionsJSON []byte) []byte {
+	a.t.Helper()
+	var options struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(optionsJSON, &options); err != nil {
+		a.t.Fatalf("unmarshal assertion options: %v", err)
+	}
+	a.signCount++
+	clientDataJSON := a.clientData("webauthn.get", options.PublicKey.Challenge)
+	authData := a.authData(0x05) // UP | UV
+	clientDataBytes, _ := base64.RawURLEncoding.DecodeString(clientDataJSON)
+	clientDataHash := sha256.Sum256(clientDataBytes)
+	digest := sha256.Sum256(append(bytes.Clone(authData), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatalf("signing assertion: %v", err)
+	}
+	return a.marshalResponse(map[string]any{
+		"clientDataJSON":    clientDataJSON,
+		"authenticatorData": b64(authData),
+		"signature":         b64(signature),
+		"userHandle":        b64(a.userHandle),
+	})
+}
+
+func (a *softwareAuthenticator) authData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	b := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(b, a.signCount)
+}
+
+func (a *softwareAuthenticator) clientData(typ string, challenge string) string {
+	b, err := json.Marshal(map[string]any{"type": typ, "challenge": challenge, "origin": passkeyTestOrigin, "crossOrigin": false})
+	if err != nil {
+		a.t.Fatalf("encoding client data: %v", err)
+	}
+	return b64(b)
+}
+
+func (a *softwareAuthenticator) marshalResponse(response map[string]any) []byte {
+	b, err := json.Marshal(map[string]any{
+		"id":                      b64(a.credentialID),
+		"rawId":                   b64(a.credentialID),
+		"type":                    "public-key",
+		"authenticatorAttachment": "platform",
+		"clientExtensionResults":  map[string]any{},
+		"response":                response,
+	})
+	if err != nil {
+		a.t.Fatalf("encoding credential response: %v", err)
+	}
+	return b
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+type passkeyMemoryUser struct {
+	id          []byte
+	name        string
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyMemoryUser) WebAuthnID() []byte                         { return u.id }
+func (u *passkeyMemoryUser) WebAuthnName() string                       { return u.name }
+func (u *passkeyMemoryUser) WebAuthnDisplayName() string                { return u.name }
+func (u *passkeyMemoryUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+// passkeyMemoryBackend stores users, webauthn credentials and credential metadata for
+// end to end passkey tests. It implements PasskeyCredentialStore.
+type passkeyMemoryBackend struct {
+	mu       sync.Mutex
+	users    map[string]*passkeyMemoryUser
+	metadata map[string][]PasskeyCredential
+}
+
+func newPasskeyMemoryBackend(users ...string) *passkeyMemoryBackend {
+	b := &passkeyMemoryBackend{users: map[string]*passkeyMemoryUser{}, metadata: map[string][]PasskeyCredential{}}
+	for _, name := range users {
+		b.users[name] = &passkeyMemoryUser{id: []byte("id-" + name), name: name}
+	}
+	return b
+}
+
+func (b *passkeyMemoryBackend) newService(t *testing.T) *PasskeyService[*passkeyMemoryUser] {
+	t.Helper()
+	service, err := NewPasskeyService[*passkeyMemoryUser](&webauthn.Config{
+		RPDisplayName: "Test RP",
+		RPID:          "example.com",
+		RPOrigins:     []string{passkeyTestOrigin},
+	}, b.saveCredential, b.loadUser, b.loadCredentialOwner)
+	if err != nil {
+		t.Fatalf("NewPasskeyService: %v", err)
+	}
+	service.LoadUserByName = b.loadUserByName
+	service.Credentials = b
+	return service
+}
+
+func (b *passkeyMemoryBackend) saveCredential(userID []byte, credential *webauthn.Credential) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	user, err := b.userLocked(userID)
+	if err != nil {
+		return err
+	}
+	for i, c := range user.credentials {
+		if bytes.Equal(c.ID, credential.ID) {
+			user.credentials[i] = *credential
+			return nil
+		}
+	}
+	user.credentials = append(user.credentials, *credential)
+	return nil
+}
+
+func (b *passkeyMemoryBackend) loadUser(userID []byte) (*passkeyMemoryUser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.userLocked(userID)
+}
+
+func (b *passkeyMemoryBackend) loadUserByName(name string) (*passkeyMemoryUser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	user, ok := b.users[name]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	return user, nil
+}
+
+func (b *passkeyMemoryBackend) loadCredentialOwner(credentialID []byte) (*passkeyMemoryUser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, user := range b.users {
+		for _, c := range user.credentials {
+			if bytes.Equal(c.ID, credentialID) {
+				return user, nil
+			}
+		}
+	}
+	return nil, ErrCredentialUnavailable
+}
+
+func (b *passkeyMemoryBackend) userLocked(userID []byte) (*passkeyMemoryUser, error) {
+	for _, user := range b.users {
+		if bytes.Equal(user.id, userID) {
+			return user, nil
+		}
+	}
+	return nil, errors.New("user not found")
+}
+
+func (b *passkeyMemoryBackend) ListCredentials(userID WebAuthnID) ([]PasskeyCredential, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]PasskeyCredential(nil), b.metadata[string(userID)]...), nil
+}
+
+func (b *passkeyMemoryBackend) UpsertCredential(userID WebAuthnID, credential PasskeyCredential) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	list := b.metadata[string(userID)]
+	for i, c := range list {
+		if bytes.Equal(c.ID, credential.ID) {
+			list[i] = credential
+			return nil
+		}
+	}
+	b.metadata[string(userID)] = append(list, credential)
+	return nil
+}
+
+func (b *passkeyMemoryBackend) DeleteCredential(userID WebAuthnID, credentialID WebAuthnID) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	user, err := b.userLocked(userID)
+	if err != nil {
+		return err
+	}
+	user.credentials = slices.DeleteFunc(user.credentials, func(c webauthn.Credential) bool { return bytes.Equal(c.ID, credentialID) })
+	b.metadata[string(userID)] = slices.DeleteFunc(b.metadata[string(userID)], func(c PasskeyCredential) bool { return bytes.Equal(c.ID, credentialID) })
+	return nil
+}
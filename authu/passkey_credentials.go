@@ -0,0 +1,213 @@
+package authu
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	gowebauthn "github.com/go-webauthn/webauthn/webauthn"
+)
+
+var ErrCloneWarning = errors.New("passkey sign count did not increase, authenticator may be cloned")
+var ErrCredentialStoreMissing = errors.New("passkey credential store not configured")
+
+const (
+	passkeyCeremonyUserLogin = "user-login"
+	passkeyCeremonyReauth    = "reauth"
+)
+
+// PasskeyCredential is the user facing view of a registered passkey used for
+// credential management.
+type PasskeyCredential struct {
+	ID           []byte
+	Name         string
+	CreatedAt    time.Time
+	LastUsedAt   time.Time
+	SignCount    uint32
+	CloneWarning bool
+	Transports   []protocol.AuthenticatorTransport
+}
+
+// PasskeyCredentialStore holds credential management metadata alongside the webauthn
+// credentials persisted through SaveCredential. DeleteCredential must remove the
+// credential entirely so that it no longer appears in WebAuthnCredentials.
+type PasskeyCredentialStore interface {
+	ListCredentials(userID WebAuthnID) ([]PasskeyCredential, error)
+	UpsertCredential(userID WebAuthnID, credential PasskeyCredential) error
+	DeleteCredential(userID WebAuthnID, credentialID WebAuthnID) error
+}
+
+// BeginLoginForUsername starts a username-first login for authenticators without
+// resident keys. The options list the user's credentials as allowCredentials.
+func (s *PasskeyService[U]) BeginLoginForUsername(username string) (string, []byte, error) {
+	if s.LoadUserByName == nil {
+		return "", nil, fmt.Errorf("missing passkey username loader")
+	}
+	user, err := s.LoadUserByName(username)
+	if err != nil {
+		return "", nil, err
+	}
+	return s.beginUserLogin(passkeyCeremonyUserLogin, user, s.userVerification())
+}
+
+func (s *PasskeyService[U]) FinishLoginForUsername(sessionID string, credentialJSON []byte) (U, error) {
+	return s.finishUserLogin(passkeyCeremonyUserLogin, nil, sessionID, credentialJSON)
+}
+
+// BeginReauthentication starts a step-up ceremony for an already signed in user. User
+// verification is always required.
+func (s *PasskeyService[U]) BeginReauthentication(userID []byte) (string, []byte, error) {
+	user, err := s.LoadUser(userID)
+	if err != nil {
+		return "", nil, err
+	}
+	return s.beginUserLogin(passkeyCeremonyReauth, user, protocol.VerificationRequired)
+}
+
+// FinishReauthentication completes a step-up ceremony, failing with ErrUserMismatch if
+// it was started for a different user.
+func (s *PasskeyService[U]) FinishReauthentication(userID []byte, sessionID string, credentialJSON []byte) (U, error) {
+	return s.finishUserLogin(passkeyCeremonyReauth, userID, sessionID, credentialJSON)
+}
+
+func (s *PasskeyService[U]) beginUserLogin(ceremony string, user U, userVerification protocol.UserVerificationRequirement) (string, []byte, error) {
+	assertion, session, err := s.inner.BeginLogin(user, gowebauthn.WithUserVerification(userVerification))
+	if err != nil {
+		return "", nil, err
+	}
+	optionsJSON, err := json.Marshal(assertion)
+	if err != nil {
+		return "", nil, fmt.Errorf("marshal login options: %w", err)
+	}
+	record, err := s.newSession(ceremony, user.WebAuthnID(), true, session)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := s.Sessions.Save(record); err != nil {
+		return "", nil, fmt.Errorf("save passkey session: %w", err)
+	}
+	return record.SessionID, optionsJSON, nil
+}
+
+func (s *PasskeyService[U]) finishUserLogin(ceremony string, expectedUserID []byte, sessionID string, credentialJSON []byte) (U, error) {
+	var zero U
+	record, err := s.consumeSession(ceremony, sessionID)
+	if err != nil {
+		return zero, err
+	}
+	if !record.HasUserID || (expectedUserID != nil && !bytes.Equal(record.UserID, expectedUserID)) {
+		return zero, ErrUserMismatch
+	}
+	user, err := s.LoadUser(record.UserID)
+	if err != nil {
+		return zero, err
+	}
+	session, err := decodeSession(record.Payload)
+	if err != nil {
+		return zero, err
+	}
+	credential, err := s.inner.FinishLogin(user, *session, httpRequestWithBody(credentialJSON))
+	if err != nil {
+		return zero, err
+	}
+	if err := s.recordCredentialUse(record.UserID, credential); err != nil {
+		return zero, err
+	}
+	return user, nil
+}
+
+// recordCredentialUse persists the updated sign count after a login, refreshes the last
+// used time and rejects logins flagged with a clone warning.
+func (s *PasskeyService[U]) recordCredentialUse(userID []byte, credential *gowebauthn.Credential) error {
+	if err := s.saveCredential(userID, credential); err != nil {
+		return err
+	}
+	if s.Credentials != nil {
+		existing, err := s.findCredential(userID, credential.ID)
+		if err != nil && !errors.Is(err, ErrCredentialUnavailable) {
+			return err
+		}
+		updated := passkeyCredentialFrom(credential, existing)
+		updated.LastUsedAt = time.Now()
+		if err := s.Credentials.UpsertCredential(userID, updated); err != nil {
+			return fmt.Errorf("update passkey credential: %w", err)
+		}
+	}
+	if credential.Authenticator.CloneWarning && !s.AllowCloneWarning {
+		return ErrCloneWarning
+	}
+	return nil
+}
+
+func (s *PasskeyService[U]) recordCredentialRegistration(userID []byte, credential *gowebauthn.Credential) error {
+	if s.Credentials == nil {
+		return nil
+	}
+	created := passkeyCredentialFrom(credential, PasskeyCredential{Name: "Passkey", CreatedAt: time.Now()})
+	if err := s.Credentials.UpsertCredential(userID, created); err != nil {
+		return fmt.Errorf("save passkey credential: %w", err)
+	}
+	return nil
+}
+
+func (s *PasskeyService[U]) ListCredentials(userID []byte) ([]PasskeyCredential, error) {
+	if s.Credentials == nil {
+		return nil, ErrCredentialStoreMissing
+	}
+	return s.Credentials.ListCredentials(userID)
+}
+
+func (s *PasskeyService[U]) RenameCredential(userID []byte, credentialID []byte, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 128 {
+		return fmt.Errorf("invalid passkey name")
+	}
+	credential, err := s.findCredential(userID, credentialID)
+	if err != nil {
+		return err
+	}
+	credential.Name = name
+	return s.Credentials.UpsertCredential(userID, credential)
+}
+
+func (s *PasskeyService[U]) DeleteCredential(userID []byte, credentialID []byte) error {
+	if _, err := s.findCredential(userID, credentialID); err != nil {
+		return err
+	}
+	return s.Credentials.DeleteCredential(userID, credentialID)
+}
+
+// findCredential looks up one of the user's credentials, so callers can't manage
+// credentials belonging to someone else.
+func (s *PasskeyService[U]) findCredential(userID []byte, credentialID []byte) (PasskeyCredential, error) {
+	credentials, err := s.ListCredentials(userID)
+	if err != nil {
+		return PasskeyCredential{}, err
+	}
+	for _, c := range credentials {
+		if bytes.Equal(c.ID, credentialID) {
+			return c, nil
+		}
+	}
+	return PasskeyCredential{}, ErrCredentialUnavailable
+}
+
+func passkeyCredentialFrom(credential *gowebauthn.Credential, existing PasskeyCredential) PasskeyCredential {
+	existing.ID = bytes.Clone(credential.ID)
+	existing.SignCount = credential.Authenticator.SignCount
+	existing.CloneWarning = existing.CloneWarning || credential.Authenticator.CloneWarning
+	if len(credential.Transport) > 0 {
+		existing.Transports = credential.Transport
+	}
+	if existing.Name == "" {
+		existing.Name = "Passkey"
+	}
+	if existing.CreatedAt.IsZero() {
+		existing.CreatedAt = time.Now()
+	}
+	return existing
+}
@@ -0,0 +1,124 @@
+package authu
+
+import (
+	"errors"
+	"testing"
+)
+
+func registerTestPasskey(t *testing.T, service *PasskeyService[*passkeyMemoryUser], authenticator *softwareAuthenticator, userID []byte) []byte {
+	t.Helper()
+	sessionID, optionsJSON, err := service.BeginRegistration(userID)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	credentialID, err := service.FinishRegistration(userID, sessionID, authenticator.register(optionsJSON))
+	if err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	return credentialID
+}
+
+func TestPasskeyServiceUsernameLoginAndReauthentication(t *testing.T) {
+	backend := newPasskeyMemoryBackend("alice", "bob")
+	service := backend.newService(t)
+	authenticator := newSoftwareAuthenticator(t, "example.com")
+	alice := backend.users["alice"]
+	registerTestPasskey(t, service, authenticator, alice.id)
+
+	sessionID, optionsJSON, err := service.BeginLoginForUsername("alice")
+	if err != nil {
+		t.Fatalf("BeginLoginForUsername: %v", err)
+	}
+	user, err := service.FinishLoginForUsername(sessionID, authenticator.login(optionsJSON))
+	if err != nil {
+		t.Fatalf("FinishLoginForUsername: %v", err)
+	}
+	if user.name != "alice" {
+		t.Fatalf("user = %v", user.name)
+	}
+
+	sessionID, optionsJSON, err = service.BeginReauthentication(alice.id)
+	if err != nil {
+		t.Fatalf("BeginReauthentication: %v", err)
+	}
+	if _, err := service.FinishReauthentication(backend.users["bob"].id, sessionID, authenticator.login(optionsJSON)); !errors.Is(err, ErrUserMismatch) {
+		t.Fatalf("FinishReauthentication other user err = %v, want ErrUserMismatch", err)
+	}
+
+	sessionID, optionsJSON, err = service.BeginReauthentication(alice.id)
+	if err != nil {
+		t.Fatalf("BeginReauthentication: %v", err)
+	}
+	if _, err := service.FinishReauthentication(alice.id, sessionID, authenticator.login(optionsJSON)); err != nil {
+		t.Fatalf("FinishReauthentication: %v", err)
+	}
+}
+
+func TestPasskeyServiceCredentialManagement(t *testing.T) {
+	backend := newPasskeyMemoryBackend("alice", "bob")
+	service := backend.newService(t)
+	authenticator := newSoftwareAuthenticator(t, "example.com")
+	alice := backend.users["alice"]
+	credentialID := registerTestPasskey(t, service, authenticator, alice.id)
+
+	credentials, err := service.ListCredentials(alice.id)
+	if err != nil {
+		t.Fatalf("ListCredentials: %v", err)
+	}
+	if len(credentials) != 1 || credentials[0].Name != "Passkey" || credentials[0].CreatedAt.IsZero() || !credentials[0].LastUsedAt.IsZero() {
+		t.Fatalf("credentials = %#v", credentials)
+	}
+
+	sessionID, optionsJSON, err := service.BeginLogin()
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	if _, err := service.FinishLogin(sessionID, authenticator.login(optionsJSON)); err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if err := service.RenameCredential(alice.id, credentialID, " Laptop "); err != nil {
+		t.Fatalf("RenameCredential: %v", err)
+	}
+	credentials, _ = service.ListCredentials(alice.id)
+	if credentials[0].Name != "Laptop" || credentials[0].LastUsedAt.IsZero() || credentials[0].SignCount != 1 {
+		t.Fatalf("credentials = %#v", credentials)
+	}
+
+	if err := service.DeleteCredential(backend.users["bob"].id, credentialID); !errors.Is(err, ErrCredentialUnavailable) {
+		t.Fatalf("DeleteCredential other user err = %v, want ErrCredentialUnavailable", err)
+	}
+	if err := service.DeleteCredential(alice.id, credentialID); err != nil {
+		t.Fatalf("DeleteCredential: %v", err)
+	}
+	if credentials, _ = service.ListCredentials(alice.id); len(credentials) != 0 || len(alice.credentials) != 0 {
+		t.Fatalf("credential not deleted")
+	}
+}
+
+func TestPasskeyServiceCloneWarning(t *testing.T) {
+	backend := newPasskeyMemoryBackend("alice")
+	service := backend.newService(t)
+	authenticator := newSoftwareAuthenticator(t, "example.com")
+	registerTestPasskey(t, service, authenticator, backend.users["alice"].id)
+
+	login := func() error {
+		sessionID, optionsJSON, err := service.BeginLogin()
+		if err != nil {
+			t.Fatalf("BeginLogin: %v", err)
+		}
+		_, err = service.FinishLogin(sessionID, authenticator.login(optionsJSON))
+		return err
+	}
+	if err := login(); err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	// a cloned authenticator replays an old sign count
+	authenticator.signCount = 0
+	if err := login(); !errors.Is(err, ErrCloneWarning) {
+		t.Fatalf("FinishLogin err = %v, want ErrCloneWarning", err)
+	}
+	credentials, _ := service.ListCredentials(backend.users["alice"].id)
+	if !credentials[0].CloneWarning {
+		t.Fatalf("clone warning not recorded: %#v", credentials)
+	}
+}
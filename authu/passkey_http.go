@@ -0,0 +1,201 @@
+package authu
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	gowebauthn "github.com/go-webauthn/webauthn/webauthn"
+)
+
+// PasskeyHandlers serves the four JSON endpoints of the passkey ceremonies. The
+// session ID of an in-flight ceremony is moved between Begin and Finish in an HttpOnly
+// cookie, and is also returned in the SessionHeader for clients that can't use cookies.
+//
+// Begin endpoints respond with the options JSON to pass to navigator.credentials, and
+// finish endpoints expect the JSON serialised credential as the request body.
+type PasskeyHandlers[U gowebauthn.User] struct {
+	Service *PasskeyService[U]
+	// CurrentUserID resolves the signed in user a passkey is registered for, e.g. from
+	// UserFromContext when mounted behind AuthMiddleware.
+	CurrentUserID func(r *http.Request) (WebAuthnID, error)
+	// OnLogin issues the app session after a successful login, e.g. with
+	// AuthMiddleware.SetAuthCookies. It must not write the response body.
+	OnLogin           func(w http.ResponseWriter, r *http.Request, user U) error
+	SessionCookieName string
+	SessionHeader     string
+	CookiePath        string
+	// Insecure drops the Secure cookie attribute, only for local plain http development.
+	Insecure     bool
+	MaxBodyBytes int64
+}
+
+func NewPasskeyHandlers[U gowebauthn.User](service *PasskeyService[U], currentUserID func(r *http.Request) (WebAuthnID, error), onLogin func(w http.ResponseWriter, r *http.Request, user U) error) *PasskeyHandlers[U] {
+	return &PasskeyHandlers[U]{
+		Service:           service,
+		CurrentUserID:     currentUserID,
+		OnLogin:           onLogin,
+		SessionCookieName: "passkey_session",
+		SessionHeader:     "X-Passkey-Session",
+		CookiePath:        "/",
+		MaxBodyBytes:      64 << 10,
+	}
+}
+
+// Handler routes POST register/begin, register/finish, login/begin and login/finish.
+// Mount it with http.StripPrefix when serving under a sub path.
+func (h *PasskeyHandlers[U]) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("POST /register/begin", h.BeginRegistration())
+	mux.Handle("POST /register/finish", h.FinishRegistration())
+	mux.Handle("POST /login/begin", h.BeginLogin())
+	mux.Handle("POST /login/finish", h.FinishLogin())
+	return mux
+}
+
+func (h *PasskeyHandlers[U]) BeginRegistration() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := h.CurrentUserID(r)
+		if err != nil {
+			passkeyHTTPError(w, http.StatusUnauthorized)
+			return
+		}
+		sessionID, optionsJSON, err := h.Service.BeginRegistration(userID)
+		if err != nil {
+			passkeyHTTPError(w, http.StatusInternalServerError)
+			return
+		}
+		h.writeOptions(w, sessionID, optionsJSON)
+	})
+}
+
+func (h *PasskeyHandlers[U]) FinishRegistration() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := h.CurrentUserID(r)
+		if err != nil {
+			passkeyHTTPError(w, http.StatusUnauthorized)
+			return
+		}
+		body, err := h.readBody(w, r)
+		if err != nil {
+			passkeyHTTPError(w, http.StatusBadRequest)
+			return
+		}
+		h.clearSessionCookie(w)
+		credentialID, err := h.Service.FinishRegistration(userID, h.sessionID(r), body)
+		if err != nil {
+			passkeyHTTPError(w, passkeyErrorStatus(err, http.StatusBadRequest))
+			return
+		}
+		writePasskeyJSON(w, http.StatusCreated, map[string]string{"credentialId": base64.RawURLEncoding.EncodeToString(credentialID)})
+	})
+}
+
+func (h *PasskeyHandlers[U]) BeginLogin() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionID, optionsJSON, err := h.Service.BeginLogin()
+		if err != nil {
+			passkeyHTTPError(w, http.StatusInternalServerError)
+			return
+		}
+		h.writeOptions(w, sessionID, optionsJSON)
+	})
+}
+
+func (h *PasskeyHandlers[U]) FinishLogin() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := h.readBody(w, r)
+		if err != nil {
+			passkeyHTTPError(w, http.StatusBadRequest)
+			return
+		}
+		h.clearSessionCookie(w)
+		user, err := h.Service.FinishLogin(h.sessionID(r), body)
+		if err != nil {
+			passkeyHTTPError(w, passkeyErrorStatus(err, http.StatusUnauthorized))
+			return
+		}
+		if h.OnLogin != nil {
+			if err := h.OnLogin(w, r, user); err != nil {
+				passkeyHTTPError(w, http.StatusInternalServerError)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (h *PasskeyHandlers[U]) writeOptions(w http.ResponseWriter, sessionID string, optionsJSON []byte) {
+	http.SetCookie(w, h.sessionCookie(sessionID, time.Now().Add(h.Service.sessionTTL())))
+	w.Header().Set(h.SessionHeader, sessionID)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(optionsJSON)
+}
+
+// sessionID prefers the header over the cookie, so clients juggling several ceremonies
+// can pick the one they mean.
+func (h *PasskeyHandlers[U]) sessionID(r *http.Request) string {
+	if sessionID := r.Header.Get(h.SessionHeader); sessionID != "" {
+		return sessionID
+	}
+	if cookie, err := r.Cookie(h.SessionCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func (h *PasskeyHandlers[U]) clearSessionCookie(w http.ResponseWriter) {
+	c := h.sessionCookie("", time.Time{})
+	c.MaxAge = -1
+	http.SetCookie(w, c)
+}
+
+func (h *PasskeyHandlers[U]) sessionCookie(value string, expires time.Time) *http.Cookie {
+	c := &http.Cookie{
+		Name:     h.SessionCookieName,
+		Value:    value,
+		Path:     h.CookiePath,
+		Expires:  expires,
+		Secure:   !h.Insecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+	if !expires.IsZero() {
+		c.MaxAge = max(int(time.Until(expires).Seconds()), 1)
+	}
+	return c
+}
+
+func (h *PasskeyHandlers[U]) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	return io.ReadAll(http.MaxBytesReader(w, r.Body, h.MaxBodyBytes))
+}
+
+// passkeyErrorStatus maps ceremony errors to a status code, using failed for
+// credentials the relying party rejected.
+func passkeyErrorStatus(err error, failed int) int {
+	var protocolErr *protocol.Error
+	switch {
+	case errors.Is(err, ErrSessionInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrUserMismatch), errors.Is(err, ErrCloneWarning):
+		return http.StatusForbidden
+	case errors.Is(err, ErrCredentialUnavailable), errors.As(err, &protocolErr):
+		return failed
+	}
+	return http.StatusInternalServerError
+}
+
+func passkeyHTTPError(w http.ResponseWriter, status int) {
+	http.Error(w, http.StatusText(status), status)
+}
+
+func writePasskeyJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,134 @@
+package authu
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+)
+
+type passkeyHTTPTestClient struct {
+	t      *testing.T
+	client *http.Client
+	url    string
+}
+
+func newPasskeyHTTPTest(t *testing.T) (*passkeyMemoryBackend, *passkeyHTTPTestClient) {
+	t.Helper()
+	backend := newPasskeyMemoryBackend("alice", "bob")
+	handlers := NewPasskeyHandlers(backend.newService(t), func(r *http.Request) (WebAuthnID, error) {
+		user, err := backend.loadUserByName(r.Header.Get("X-Test-User"))
+		if err != nil {
+			return nil, errors.New("not signed in")
+		}
+		return user.id, nil
+	}, func(w http.ResponseWriter, r *http.Request, user *passkeyMemoryUser) error {
+		http.SetCookie(w, &http.Cookie{Name: "app", Value: user.name, Path: "/", Secure: true, HttpOnly: true})
+		return nil
+	})
+	server := httptest.NewTLSServer(handlers.Handler())
+	t.Cleanup(server.Close)
+	client := server.Client()
+	client.Jar, _ = cookiejar.New(nil)
+	return backend, &passkeyHTTPTestClient{t: t, client: client, url: server.URL}
+}
+
+func (c *passkeyHTTPTestClient) post(path string, user string, body []byte) (*http.Response, []byte) {
+	c.t.Helper()
+	req, err := http.NewRequest(http.MethodPost, c.url+path, bytes.NewReader(body))
+	if err != nil {
+		c.t.Fatalf("NewRequest: %v", err)
+	}
+	if user != "" {
+		req.Header.Set("X-Test-User", user)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.t.Fatalf("POST %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp, b
+}
+
+func (c *passkeyHTTPTestClient) cookie(name string) string {
+	req, _ := http.NewRequest(http.MethodGet, c.url, nil)
+	for _, cookie := range c.client.Jar.Cookies(req.URL) {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func TestPasskeyHandlersEndToEnd(t *testing.T) {
+	backend, client := newPasskeyHTTPTest(t)
+	authenticator := newSoftwareAuthenticator(t, "example.com")
+
+	if resp, _ := client.post("/register/begin", "", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("anonymous register begin status = %d", resp.StatusCode)
+	}
+	resp, optionsJSON := client.post("/register/begin", "alice", nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("register begin status = %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if client.cookie("passkey_session") == "" {
+		t.Fatalf("session cookie not set")
+	}
+	if resp, body := client.post("/register/finish", "alice", authenticator.register(optionsJSON)); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("register finish status = %d: %s", resp.StatusCode, body)
+	}
+	if client.cookie("passkey_session") != "" {
+		t.Fatalf("session cookie not cleared")
+	}
+	if len(backend.users["alice"].credentials) != 1 {
+		t.Fatalf("credential not saved")
+	}
+
+	resp, optionsJSON = client.post("/login/begin", "", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("login begin status = %d", resp.StatusCode)
+	}
+	credentialJSON := authenticator.login(optionsJSON)
+	if resp, body := client.post("/login/finish", "", credentialJSON); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("login finish status = %d: %s", resp.StatusCode, body)
+	}
+	if client.cookie("app") != "alice" {
+		t.Fatalf("app session not issued")
+	}
+	// the ceremony session was consumed, so a replay has nothing to finish
+	if resp, _ := client.post("/login/finish", "", credentialJSON); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("replayed login finish status = %d", resp.StatusCode)
+	}
+}
+
+func TestPasskeyHandlersErrors(t *testing.T) {
+	_, client := newPasskeyHTTPTest(t)
+	authenticator := newSoftwareAuthenticator(t, "example.com")
+
+	_, optionsJSON := client.post("/register/begin", "alice", nil)
+	if resp, _ := client.post("/register/finish", "bob", authenticator.register(optionsJSON)); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("register finish as other user status = %d", resp.StatusCode)
+	}
+
+	_, optionsJSON = client.post("/login/begin", "", nil)
+	// the authenticator's credential was never registered
+	if resp, _ := client.post("/login/finish", "", authenticator.login(optionsJSON)); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unknown credential login status = %d", resp.StatusCode)
+	}
+	if resp, _ := client.post("/login/finish", "", []byte("{}")); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("login finish without session status = %d", resp.StatusCode)
+	}
+	req, _ := http.NewRequest(http.MethodGet, client.url+"/login/begin", nil)
+	resp, err := client.client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.Method, req.URL.Path, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("GET login begin status = %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,196 @@
+package authu
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// PasskeySession is an in-flight passkey ceremony. Payload holds the msgp encoded
+// webauthn session data, so the whole record is safe to persist.
+type PasskeySession struct {
+	SessionID string
+	Ceremony  string
+	UserID    []byte
+	HasUserID bool
+	ExpiresAt time.Time
+	Payload   []byte
+}
+
+type PasskeySessionStore interface {
+	Save(session PasskeySession) error
+	// Consume returns and removes the session of the ceremony in one step so it can
+	// only be used once. Sessions are keyed by ceremony and id, so presenting a session
+	// id to the wrong ceremony doesn't remove it. Unknown or expired sessions return
+	// ErrSessionInvalid.
+	Consume(ceremony string, sessionID string) (*PasskeySession, error)
+}
+
+// passkeySessionSweepInterval is how often the stores look for expired sessions.
+const passkeySessionSweepInterval = time.Minute
+
+type passkeySessionKey struct {
+	ceremony  string
+	sessionID string
+}
+
+// InMemoryPasskeySessionStore is the default PasskeySessionStore. Ceremonies must
+// begin and finish on the same instance.
+type InMemoryPasskeySessionStore struct {
+	mu        sync.Mutex
+	records   map[passkeySessionKey]PasskeySession
+	lastSweep time.Time
+}
+
+func NewInMemoryPasskeySessionStore() *InMemoryPasskeySessionStore {
+	return &InMemoryPasskeySessionStore{records: map[passkeySessionKey]PasskeySession{}}
+}
+
+func (s *InMemoryPasskeySessionStore) Save(record PasskeySession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteExpiredLocked(time.Now())
+	s.records[passkeySessionKey{record.Ceremony, record.SessionID}] = record
+	return nil
+}
+
+func (s *InMemoryPasskeySessionStore) Consume(ceremony string, sessionID string) (*PasskeySession, error) {
+	now := time.Now()
+	key := passkeySessionKey{ceremony, sessionID}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[key]
+	if !ok {
+		return nil, ErrSessionInvalid
+	}
+	delete(s.records, key)
+	if now.After(record.ExpiresAt) {
+		return nil, ErrSessionInvalid
+	}
+	return &record, nil
+}
+
+// deleteExpiredLocked drops expired sessions, at most once per sweep interval so
+// saving stays cheap with many sessions in flight.
+func (s *InMemoryPasskeySessionStore) deleteExpiredLocked(now time.Time) {
+	if now.Sub(s.lastSweep) < passkeySessionSweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, record := range s.records {
+		if now.After(record.ExpiresAt) {
+			delete(s.records, key)
+		}
+	}
+}
+
+// FilePasskeySessionStore keeps sessions as files in a directory shared between
+// replicas. Consume claims a session by renaming its file, which is atomic, so a
+// session can't be consumed twice even across processes.
+type FilePasskeySessionStore struct {
+	Dir string
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewFilePasskeySessionStore(dir string) (*FilePasskeySessionStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating passkey session dir %q: %w", dir, err)
+	}
+	return &FilePasskeySessionStore{Dir: dir}, nil
+}
+
+func (s *FilePasskeySessionStore) Save(record PasskeySession) error {
+	s.deleteExpired(time.Now())
+	b, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encoding passkey session: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.Dir, "*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// the modification time doubles as the expiry so cleanup doesn't need to read files
+	if err := os.Chtimes(tmp.Name(), record.ExpiresAt, record.ExpiresAt); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(record.Ceremony, record.SessionID))
+}
+
+func (s *FilePasskeySessionStore) Consume(ceremony string, sessionID string) (*PasskeySession, error) {
+	path := s.path(ceremony, sessionID)
+	claimed := path + ".consumed"
+	if err := os.Rename(path, claimed); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrSessionInvalid
+		}
+		return nil, err
+	}
+	defer os.Remove(claimed)
+	b, err := os.ReadFile(claimed)
+	if err != nil {
+		return nil, err
+	}
+	var record PasskeySession
+	if err := json.Unmarshal(b, &record); err != nil {
+		return nil, fmt.Errorf("decoding passkey session: %w", err)
+	}
+	if record.SessionID != sessionID || record.Ceremony != ceremony || time.Now().After(record.ExpiresAt) {
+		return nil, ErrSessionInvalid
+	}
+	return &record, nil
+}
+
+// deleteExpired removes expired session files, at most once per sweep interval per
+// process, so a save doesn't have to scan the directory.
+func (s *FilePasskeySessionStore) deleteExpired(now time.Time) {
+	s.mu.Lock()
+	due := now.Sub(s.lastSweep) >= passkeySessionSweepInterval
+	if due {
+		s.lastSweep = now
+	}
+	s.mu.Unlock()
+	if !due {
+		return
+	}
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		expired := now.After(info.ModTime())
+		if filepath.Ext(entry.Name()) != ".session" {
+			// leftover temp and claimed files are only ever short lived
+			expired = now.Sub(info.ModTime()) > time.Hour
+		}
+		if expired {
+			os.Remove(filepath.Join(s.Dir, entry.Name()))
+		}
+	}
+}
+
+// path hashes the ceremony and session id so client supplied ids can't escape Dir.
+func (s *FilePasskeySessionStore) path(ceremony string, sessionID string) string {
+	sum := sha256.Sum256([]byte(ceremony + "\x00" + sessionID))
+	return filepath.Join(s.Dir, hex.EncodeToString(sum[:])+".session")
+}
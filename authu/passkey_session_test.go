@@ -0,0 +1,79 @@
+package authu
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPasskeySessionStores(t *testing.T) {
+	fileStore, err := NewFilePasskeySessionStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilePasskeySessionStore: %v", err)
+	}
+	stores := map[string]PasskeySessionStore{
+		"memory": NewInMemoryPasskeySessionStore(),
+		"file":   fileStore,
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			record := PasskeySession{
+				SessionID: "session-1",
+				Ceremony:  passkeyCeremonyLogin,
+				UserID:    []byte("user"),
+				HasUserID: true,
+				ExpiresAt: time.Now().Add(time.Minute),
+				Payload:   []byte{1, 2, 3},
+			}
+			if err := store.Save(record); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			got, err := store.Consume(passkeyCeremonyLogin, "session-1")
+			if err != nil {
+				t.Fatalf("Consume: %v", err)
+			}
+			if got.Ceremony != record.Ceremony || string(got.UserID) != "user" || !got.HasUserID || len(got.Payload) != 3 {
+				t.Fatalf("consumed = %#v", got)
+			}
+			if _, err := store.Consume(passkeyCeremonyLogin, "session-1"); !errors.Is(err, ErrSessionInvalid) {
+				t.Fatalf("second Consume err = %v, want ErrSessionInvalid", err)
+			}
+
+			record.SessionID = "expired"
+			record.ExpiresAt = time.Now().Add(-time.Second)
+			if err := store.Save(record); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			if _, err := store.Consume(passkeyCeremonyLogin, "expired"); !errors.Is(err, ErrSessionInvalid) {
+				t.Fatalf("expired Consume err = %v, want ErrSessionInvalid", err)
+			}
+
+			record.SessionID = "session-2"
+			record.ExpiresAt = time.Now().Add(time.Minute)
+			if err := store.Save(record); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			if _, err := store.Consume(passkeyCeremonyRegistration, "session-2"); !errors.Is(err, ErrSessionInvalid) {
+				t.Fatalf("other ceremony Consume err = %v, want ErrSessionInvalid", err)
+			}
+			if _, err := store.Consume(passkeyCeremonyLogin, "session-2"); err != nil {
+				t.Fatalf("Consume after other ceremony: %v", err)
+			}
+		})
+	}
+}
+
+func TestPasskeyServiceRejectsSessionFromOtherCeremony(t *testing.T) {
+	service := newPasskeyTestService(t, "")
+	sessionID, _, err := service.BeginLogin()
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	if _, err := service.FinishRegistration([]byte("test-user"), sessionID, []byte("{}")); !errors.Is(err, ErrSessionInvalid) {
+		t.Fatalf("FinishRegistration err = %v, want ErrSessionInvalid", err)
+	}
+	// presenting the id to the wrong ceremony must not burn the login session
+	if _, err := service.Sessions.Consume(passkeyCeremonyLogin, sessionID); err != nil {
+		t.Fatalf("login session consumed by the registration attempt: %v", err)
+	}
+}
@@ -5,8 +5,12 @@ import (
 	"crypto/subtle"
 	"encoding/base64"
 	"errors"
+	"fmt"
+	"strings"
 
 	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
 )
 
 const (
@@ -16,18 +20,117 @@ const (
 	pKeyLength  = 32
 )
 
+// Upper bounds on the parameters of hashes to verify, so a stored or imported hash with
+// absurd parameters is rejected rather than exhausting memory on a login attempt.
+const (
+	maxArgon2Memory  = 1 << 20 // KiB, 1 GiB
+	maxArgon2Time    = 32
+	maxArgon2Threads = 64
+	maxSaltLength    = 64
+	maxKeyLength     = 128
+	maxScryptLogN    = 20
+	maxScryptMemory  = 1 << 30 // bytes
+	maxScryptThreads = 16
+	maxScryptRP      = 32 // r·p, scrypt's work is N·r·p
+	maxBcryptCost    = 14
+)
+
+var ErrInvalidPasswordHash = errors.New("invalid password hash")
+
+// PasswordParams are the argon2id parameters used to hash new passwords. They are
+// stored in the hash itself, so changing them never breaks existing hashes.
+type PasswordParams struct {
+	Memory     uint32 // KiB
+	Time       uint32
+	Threads    uint8
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+// DefaultPasswordParams are used by HashPassword and NeedsRehash.
+var DefaultPasswordParams = PasswordParams{
+	Memory:     pMemory,
+	Time:       pTime,
+	Threads:    1,
+	SaltLength: pSaltLength,
+	KeyLength:  pKeyLength,
+}
+
+// HashPassword hashes with DefaultPasswordParams into a PHC string like
+// $argon2id$v=19$m=65536,t=3,p=1$<salt>$<hash>.
 func HashPassword(password string) (string, error) {
-	salt := make([]byte, pSaltLength)
+	return DefaultPasswordParams.Hash(password)
+}
+
+func (p PasswordParams) Hash(password string) (string, error) {
+	if err := p.validate(); err != nil {
+		return "", err
+	}
+	salt := make([]byte, p.SaltLength)
 	if _, err := rand.Read(salt); err != nil {
 		return "", err
 	}
-	hash := argon2.IDKey([]byte(password), salt, pTime, pMemory, 1, pKeyLength)
-	// Concatenate salt + hash
-	combined := append(salt, hash...)
-	return base64.RawStdEncoding.EncodeToString(combined), nil
+	hash := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
 }
 
+// NeedsRehash reports whether passwordHash should be replaced by HashPassword after a
+// successful login, because it uses another algorithm, the legacy format or different
+// parameters than DefaultPasswordParams.
+func NeedsRehash(passwordHash string) bool {
+	return DefaultPasswordParams.NeedsRehash(passwordHash)
+}
+
+func (p PasswordParams) NeedsRehash(passwordHash string) bool {
+	params, salt, hash, err := parseArgon2idHash(passwordHash)
+	if err != nil {
+		return true
+	}
+	return params.Memory != p.Memory || params.Time != p.Time || params.Threads != p.Threads ||
+		len(salt) != int(p.SaltLength) || len(hash) != int(p.KeyLength)
+}
+
+// VerifyPassword checks password against an argon2id PHC string or the legacy base64
+// salt+hash format. To ease migrating imported users it also accepts bcrypt hashes and
+// scrypt hashes in the $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash> form, all of which
+// NeedsRehash reports for upgrading.
 func VerifyPassword(password, passwordHash string) (bool, error) {
+	switch {
+	case strings.HasPrefix(passwordHash, "$argon2id$"):
+		params, salt, hash, err := parseArgon2idHash(passwordHash)
+		if err != nil {
+			return false, err
+		}
+		computedHash := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, params.KeyLength)
+		return subtle.ConstantTimeCompare(computedHash, hash) == 1, nil
+	case strings.HasPrefix(passwordHash, "$2a$"), strings.HasPrefix(passwordHash, "$2b$"), strings.HasPrefix(passwordHash, "$2y$"):
+		cost, err := bcrypt.Cost([]byte(passwordHash))
+		if err != nil {
+			return false, fmt.Errorf("%w: %w", ErrInvalidPasswordHash, err)
+		}
+		if cost > maxBcryptCost {
+			return false, fmt.Errorf("%w: bcrypt cost %d above %d", ErrInvalidPasswordHash, cost, maxBcryptCost)
+		}
+		err = bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("%w: %w", ErrInvalidPasswordHash, err)
+		}
+		return true, nil
+	case strings.HasPrefix(passwordHash, "$scrypt$"):
+		return verifyScryptPassword(password, passwordHash)
+	case strings.HasPrefix(passwordHash, "$"):
+		return false, fmt.Errorf("%w: unsupported algorithm", ErrInvalidPasswordHash)
+	}
+	return verifyLegacyPassword(password, passwordHash)
+}
+
+// verifyLegacyPassword checks hashes from before the PHC format, a bare base64 salt+hash
+// made with the then fixed parameters.
+func verifyLegacyPassword(password, passwordHash string) (bool, error) {
 	combined, err := base64.RawStdEncoding.DecodeString(passwordHash)
 	if err != nil {
 		return false, err
@@ -40,3 +143,79 @@ func VerifyPassword(password, passwordHash string) (bool, error) {
 	computedHash := argon2.IDKey([]byte(password), salt, pTime, pMemory, 1, pKeyLength)
 	return subtle.ConstantTimeCompare(computedHash, hash) == 1, nil
 }
+
+func parseArgon2idHash(passwordHash string) (PasswordParams, []byte, []byte, error) {
+	var params PasswordParams
+	parts := strings.Split(passwordHash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version || parts[2] != fmt.Sprintf("v=%d", version) {
+		return params, nil, nil, fmt.Errorf("%w: unsupported argon2 version", ErrInvalidPasswordHash)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, fmt.Errorf("%w: %w", ErrInvalidPasswordHash, err)
+	}
+	// Sscanf stops at the last verb, so reject anything it left unread
+	if parts[3] != fmt.Sprintf("m=%d,t=%d,p=%d", params.Memory, params.Time, params.Threads) {
+		return params, nil, nil, fmt.Errorf("%w: bad argon2id parameters", ErrInvalidPasswordHash)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("%w: %w", ErrInvalidPasswordHash, err)
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("%w: %w", ErrInvalidPasswordHash, err)
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(hash))
+	if err := params.validate(); err != nil {
+		return params, nil, nil, err
+	}
+	return params, salt, hash, nil
+}
+
+func verifyScryptPassword(password, passwordHash string) (bool, error) {
+	parts := strings.Split(passwordHash, "$")
+	if len(parts) != 5 || parts[0] != "" {
+		return false, ErrInvalidPasswordHash
+	}
+	var logN, r, p int
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &logN, &r, &p); err != nil || !validScryptParams(logN, r, p) ||
+		parts[2] != fmt.Sprintf("ln=%d,r=%d,p=%d", logN, r, p) {
+		return false, fmt.Errorf("%w: bad scrypt parameters", ErrInvalidPasswordHash)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", ErrInvalidPasswordHash, err)
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(hash) == 0 || len(hash) > maxKeyLength {
+		return false, fmt.Errorf("%w: bad scrypt hash", ErrInvalidPasswordHash)
+	}
+	computedHash, err := scrypt.Key([]byte(password), salt, 1<<logN, r, p, len(hash))
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", ErrInvalidPasswordHash, err)
+	}
+	return subtle.ConstantTimeCompare(computedHash, hash) == 1, nil
+}
+
+// validScryptParams bounds the 128·N·r bytes scrypt allocates and, through p and r·p,
+// the work it does on top of that.
+func validScryptParams(logN, r, p int) bool {
+	if logN < 1 || logN > maxScryptLogN || r < 1 || p < 1 || p > maxScryptThreads || r > maxScryptRP {
+		return false
+	}
+	return r*p <= maxScryptRP && 128*int64(r)<<logN <= maxScryptMemory
+}
+
+func (p PasswordParams) validate() error {
+	if p.Time < 1 || p.Threads < 1 || p.Memory < 8*uint32(p.Threads) || p.SaltLength < 8 || p.KeyLength < 16 ||
+		p.Time > maxArgon2Time || p.Threads > maxArgon2Threads || p.Memory > maxArgon2Memory ||
+		p.SaltLength > maxSaltLength || p.KeyLength > maxKeyLength {
+		return fmt.Errorf("%w: bad argon2id parameters", ErrInvalidPasswordHash)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package authu
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/jptrs93/goutil/syncu"
+)
+
+var ErrPasswordHasherBusy = errors.New("password hasher queue full")
+
+// PasswordHasher runs HashPassword and VerifyPassword with bounded concurrency. Each
+// argon2id hash allocates PasswordParams.Memory, so an unbounded burst of logins can
+// exhaust memory; instead calls queue for a slot and, when MaxQueue is set, are
+// rejected with ErrPasswordHasherBusy once the queue is full. The zero value allows
+// one hash per CPU with DefaultPasswordParams.
+type PasswordHasher struct {
+	// Params are used for new hashes, the zero value means DefaultPasswordParams.
+	Params PasswordParams
+	// MaxQueue is the number of calls allowed to wait for a slot, 0 means unbounded.
+	MaxQueue int
+	sem      syncu.ChanLock
+	semOnce  sync.Once
+
+	mu    sync.Mutex
+	stats PasswordHasherStats
+}
+
+type PasswordHasherStats struct {
+	Running   int
+	Queued    int
+	Completed uint64
+	Rejected  uint64
+	// TotalWait and MaxWait cover the time calls spent queued for a slot.
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+// NewPasswordHasher allows concurrency simultaneous hashes, defaulting to the number of
+// CPUs when concurrency <= 0.
+func NewPasswordHasher(concurrency int) *PasswordHasher {
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	return &PasswordHasher{Params: DefaultPasswordParams, sem: syncu.NewChanLock(concurrency)}
+}
+
+func (h *PasswordHasher) Hash(ctx context.Context, password string) (string, error) {
+	if err := h.acquire(ctx); err != nil {
+		return "", err
+	}
+	defer h.release()
+	return h.params().Hash(password)
+}
+
+func (h *PasswordHasher) Verify(ctx context.Context, password, passwordHash string) (bool, error) {
+	if err := h.acquire(ctx); err != nil {
+		return false, err
+	}
+	defer h.release()
+	return VerifyPassword(password, passwordHash)
+}
+
+func (h *PasswordHasher) NeedsRehash(passwordHash string) bool {
+	return h.params().NeedsRehash(passwordHash)
+}
+
+func (h *PasswordHasher) params() PasswordParams {
+	if h.Params == (PasswordParams{}) {
+		return DefaultPasswordParams
+	}
+	return h.Params
+}
+
+func (h *PasswordHasher) Stats() PasswordHasherStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stats
+}
+
+func (h *PasswordHasher) acquire(ctx context.Context) error {
+	h.semOnce.Do(func() {
+		if h.sem == nil {
+			h.sem = syncu.NewChanLock(runtime.NumCPU())
+		}
+	})
+	if h.sem.TryLock() {
+		h.mu.Lock()
+		h.stats.Running++
+		h.mu.Unlock()
+		return nil
+	}
+	h.mu.Lock()
+	if h.MaxQueue > 0 && h.stats.Queued >= h.MaxQueue {
+		h.stats.Rejected++
+		h.mu.Unlock()
+		return ErrPasswordHasherBusy
+	}
+	h.stats.Queued++
+	h.mu.Unlock()
+
+	start := time.Now()
+	err := h.sem.Lock(ctx)
+	wait := time.Since(start)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stats.Queued--
+	h.stats.TotalWait += wait
+	h.stats.MaxWait = max(h.stats.MaxWait, wait)
+	if err != nil {
+		return err
+	}
+	h.stats.Running++
+	return nil
+}
+
+func (h *PasswordHasher) release() {
+	h.mu.Lock()
+	h.stats.Running--
+	h.stats.Completed++
+	h.mu.Unlock()
+	h.sem.Unlock()
+}
@@ -0,0 +1,87 @@
+package authu
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPasswordHasherHashAndVerify(t *testing.T) {
+	h := NewPasswordHasher(2)
+	h.Params = testPasswordParams
+	hash, err := h.Hash(context.Background(), "hunter2")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if ok, err := h.Verify(context.Background(), "hunter2", hash); err != nil || !ok {
+		t.Fatalf("Verify = %v, %v", ok, err)
+	}
+	if h.NeedsRehash(hash) {
+		t.Fatalf("NeedsRehash = true")
+	}
+	if stats := h.Stats(); stats.Completed != 2 || stats.Running != 0 || stats.Queued != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestPasswordHasherZeroValue(t *testing.T) {
+	var h PasswordHasher
+	h.Params = testPasswordParams
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	hash, err := h.Hash(ctx, "hunter2")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if ok, err := h.Verify(ctx, "hunter2", hash); err != nil || !ok {
+		t.Fatalf("Verify = %v, %v", ok, err)
+	}
+	var defaults PasswordHasher
+	if defaults.NeedsRehash(hash) == testPasswordParams.NeedsRehash(hash) {
+		t.Fatalf("zero Params should fall back to DefaultPasswordParams")
+	}
+}
+
+func TestPasswordHasherQueueing(t *testing.T) {
+	h := NewPasswordHasher(1)
+	h.Params = testPasswordParams
+	h.MaxQueue = 1
+	// hold the only slot so later calls have to queue
+	if !h.sem.TryLock() {
+		t.Fatal("slot unavailable")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := h.Hash(ctx, "hunter2")
+		done <- err
+	}()
+	for h.Stats().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := h.Hash(context.Background(), "hunter2"); !errors.Is(err, ErrPasswordHasherBusy) {
+		t.Fatalf("Hash with full queue err = %v, want ErrPasswordHasherBusy", err)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled Hash err = %v", err)
+	}
+
+	go func() {
+		_, err := h.Hash(context.Background(), "hunter2")
+		done <- err
+	}()
+	for h.Stats().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	h.sem.Unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("queued Hash: %v", err)
+	}
+	stats := h.Stats()
+	if stats.Rejected != 1 || stats.Completed != 1 || stats.Queued != 0 || stats.MaxWait <= 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
@@ -0,0 +1,123 @@
+package authu
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// cheap parameters keep the tests fast
+var testPasswordParams = PasswordParams{Memory: 64, Time: 1, Threads: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHashPasswordPHC(t *testing.T) {
+	hash, err := testPasswordParams.Hash("hunter2")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("hash = %q", hash)
+	}
+	if ok, err := VerifyPassword("hunter2", hash); err != nil || !ok {
+		t.Fatalf("VerifyPassword = %v, %v", ok, err)
+	}
+	if ok, err := VerifyPassword("hunter3", hash); err != nil || ok {
+		t.Fatalf("VerifyPassword wrong password = %v, %v", ok, err)
+	}
+	if testPasswordParams.NeedsRehash(hash) {
+		t.Fatalf("NeedsRehash with same params")
+	}
+	stronger := testPasswordParams
+	stronger.Time = 2
+	if !stronger.NeedsRehash(hash) {
+		t.Fatalf("NeedsRehash with stronger params = false")
+	}
+}
+
+func TestVerifyPasswordLegacyFormat(t *testing.T) {
+	salt := make([]byte, pSaltLength)
+	_, _ = rand.Read(salt)
+	legacy := base64.RawStdEncoding.EncodeToString(append(salt, argon2.IDKey([]byte("hunter2"), salt, pTime, pMemory, 1, pKeyLength)...))
+	if ok, err := VerifyPassword("hunter2", legacy); err != nil || !ok {
+		t.Fatalf("VerifyPassword legacy = %v, %v", ok, err)
+	}
+	if ok, _ := VerifyPassword("hunter3", legacy); ok {
+		t.Fatalf("VerifyPassword legacy wrong password = true")
+	}
+	if !NeedsRehash(legacy) {
+		t.Fatalf("NeedsRehash legacy = false")
+	}
+}
+
+func TestVerifyPasswordImportedHashes(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt.GenerateFromPassword: %v", err)
+	}
+	salt := []byte("0123456789abcdef")
+	key, err := scrypt.Key([]byte("hunter2"), salt, 1<<10, 8, 1, 32)
+	if err != nil {
+		t.Fatalf("scrypt.Key: %v", err)
+	}
+	scryptHash := fmt.Sprintf("$scrypt$ln=10,r=8,p=1$%s$%s", base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+
+	for name, hash := range map[string]string{"bcrypt": string(bcryptHash), "scrypt": scryptHash} {
+		if ok, err := VerifyPassword("hunter2", hash); err != nil || !ok {
+			t.Fatalf("%s VerifyPassword = %v, %v", name, ok, err)
+		}
+		if ok, err := VerifyPassword("hunter3", hash); err != nil || ok {
+			t.Fatalf("%s VerifyPassword wrong password = %v, %v", name, ok, err)
+		}
+		if !NeedsRehash(hash) {
+			t.Fatalf("%s NeedsRehash = false", name)
+		}
+	}
+}
+
+func TestVerifyPasswordRejectsMalformedHashes(t *testing.T) {
+	for _, hash := range []string{
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA",
+		"$argon2id$v=19$m=64$c2FsdHNhbHQ$aGFzaA",
+		"$md5$abc",
+		// parameters that would exhaust memory or cpu
+		"$argon2id$v=19$m=4294967295,t=1,p=1$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA",
+		"$argon2id$v=19$m=65536,t=4294967295,p=1$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA",
+		"$argon2id$v=19$m=65536,t=1,p=255$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA",
+		"$scrypt$ln=30,r=8,p=1$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA",
+		"$scrypt$ln=20,r=16,p=1$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA",
+		"$scrypt$ln=10,r=1073741824,p=1$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA",
+		"$scrypt$ln=10,r=8,p=1073741824$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA",
+		"$scrypt$ln=10,r=8,p=17$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA",
+		"$scrypt$ln=10,r=16,p=4$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA",
+		// trailing input after the parameters
+		"$argon2id$v=19$m=65536,t=1,p=1junk$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA",
+		"$argon2id$v=19x$m=65536,t=1,p=1$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA",
+		"$scrypt$ln=10,r=8,p=1,x=2$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA",
+	} {
+		if _, err := VerifyPassword("hunter2", hash); !errors.Is(err, ErrInvalidPasswordHash) {
+			t.Fatalf("VerifyPassword(%q) err = %v, want ErrInvalidPasswordHash", hash, err)
+		}
+	}
+}
+
+func TestVerifyPasswordRejectsExpensiveBcrypt(t *testing.T) {
+	// a hash claiming cost 31 would take days to check, the cost field is all that's read
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt.GenerateFromPassword: %v", err)
+	}
+	expensive := fmt.Sprintf("%s%02d%s", hash[:4], maxBcryptCost+1, hash[6:])
+	if _, err := VerifyPassword("hunter2", expensive); !errors.Is(err, ErrInvalidPasswordHash) {
+		t.Fatalf("VerifyPassword cost %d err = %v, want ErrInvalidPasswordHash", maxBcryptCost+1, err)
+	}
+	if ok, err := VerifyPassword("hunter2", string(hash)); err != nil || !ok {
+		t.Fatalf("VerifyPassword = %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,246 @@
+package authu
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrRefreshTokenInvalid = errors.New("refresh token invalid")
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// RefreshTokenRecord is what a RefreshTokenStore keeps per issued refresh token. The
+// token itself is never stored, only its hash.
+type RefreshTokenRecord struct {
+	Hash      string
+	FamilyID  string
+	Subject   string
+	Scopes    []string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	Used      bool
+	Revoked   bool
+}
+
+type RefreshTokenStore interface {
+	Save(ctx context.Context, record RefreshTokenRecord) error
+	// Load returns ErrRefreshTokenInvalid when no record exists for hash.
+	Load(ctx context.Context, hash string) (RefreshTokenRecord, error)
+	// Rotate atomically flags the token as used and saves its successor, returning
+	// ErrRefreshTokenReused without saving if it already was used, and
+	// ErrRefreshTokenInvalid if it was revoked or has expired. Doing it all in one step
+	// means a failure can't burn a token without handing out the next one, and a
+	// family revoked since the token was loaded can't gain a new member.
+	Rotate(ctx context.Context, usedHash string, successor RefreshTokenRecord) error
+	// RevokeFamily revokes every token descending from the same initial login.
+	RevokeFamily(ctx context.Context, familyID string) error
+}
+
+type TokenPair struct {
+	AccessToken      string
+	RefreshToken     string
+	AccessExpiresAt  time.Time
+	RefreshExpiresAt time.Time
+}
+
+// RefreshTokens issues short-lived JWT access tokens paired with opaque single-use
+// refresh tokens. Every refresh rotates the refresh token; replaying an already used
+// one revokes the whole family, logging out both the attacker and the victim.
+type RefreshTokens[T any, K any] struct {
+	Auth      *JWTAuth[T, K]
+	Store     RefreshTokenStore
+	AccessTTL time.Duration
+	// RefreshTTL is the lifetime of a token family, counted from Issue. Rotations
+	// don't extend it, so a login has to be repeated at least that often.
+	RefreshTTL time.Duration
+}
+
+func NewRefreshTokens[T any, K any](auth *JWTAuth[T, K], store RefreshTokenStore) *RefreshTokens[T, K] {
+	return &RefreshTokens[T, K]{
+		Auth:       auth,
+		Store:      store,
+		AccessTTL:  15 * time.Minute,
+		RefreshTTL: 30 * 24 * time.Hour,
+	}
+}
+
+// Issue starts a new token family, e.g. after a successful login.
+func (r *RefreshTokens[T, K]) Issue(ctx context.Context, sub K, scopes []string) (TokenPair, error) {
+	encodedSub, err := encodeJWTSubject(sub)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	familyID, err := GenerateRandomToken(16)
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("generating token family id: %w", err)
+	}
+	pair, record, err := r.newPair(sub, encodedSub, familyID, scopes, time.Now().Add(r.RefreshTTL))
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if err := r.Store.Save(ctx, record); err != nil {
+		return TokenPair{}, fmt.Errorf("saving refresh token: %w", err)
+	}
+	return pair, nil
+}
+
+// Refresh exchanges a refresh token for a new token pair and resolves the subject
+// through JWTAuth.UserLoader. The presented token is only used up once the new pair is
+// ready, so a failure along the way leaves it valid for the client to retry.
+func (r *RefreshTokens[T, K]) Refresh(ctx context.Context, refreshToken string) (TokenPair, T, error) {
+	var zero T
+	hash := hashRefreshToken(refreshToken)
+	record, err := r.Store.Load(ctx, hash)
+	if err != nil {
+		return TokenPair{}, zero, err
+	}
+	if record.Revoked || time.Now().After(record.ExpiresAt) {
+		return TokenPair{}, zero, ErrRefreshTokenInvalid
+	}
+	if record.Used {
+		return TokenPair{}, zero, r.revokeReused(ctx, record)
+	}
+	sub, err := decodeJWTSubject[K](record.Subject)
+	if err != nil {
+		return TokenPair{}, zero, err
+	}
+	user, err := r.Auth.UserLoader(sub)
+	if err != nil {
+		return TokenPair{}, zero, fmt.Errorf("resolving user: %w", err)
+	}
+	pair, successor, err := r.newPair(sub, record.Subject, record.FamilyID, record.Scopes, record.ExpiresAt)
+	if err != nil {
+		return TokenPair{}, zero, err
+	}
+	if err := r.Store.Rotate(ctx, hash, successor); err != nil {
+		if errors.Is(err, ErrRefreshTokenReused) {
+			return TokenPair{}, zero, r.revokeReused(ctx, record)
+		}
+		return TokenPair{}, zero, fmt.Errorf("rotating refresh token: %w", err)
+	}
+	return pair, user, nil
+}
+
+// Revoke revokes the family of the given refresh token, e.g. on logout.
+func (r *RefreshTokens[T, K]) Revoke(ctx context.Context, refreshToken string) error {
+	record, err := r.Store.Load(ctx, hashRefreshToken(refreshToken))
+	if err != nil {
+		return err
+	}
+	return r.Store.RevokeFamily(ctx, record.FamilyID)
+}
+
+func (r *RefreshTokens[T, K]) revokeReused(ctx context.Context, record RefreshTokenRecord) error {
+	if err := r.Store.RevokeFamily(ctx, record.FamilyID); err != nil {
+		return fmt.Errorf("revoking token family: %w", err)
+	}
+	return ErrRefreshTokenReused
+}
+
+// newPair generates a token pair and the record to store for its refresh token, which
+// expires with its family at expiresAt.
+func (r *RefreshTokens[T, K]) newPair(sub K, encodedSub string, familyID string, scopes []string, expiresAt time.Time) (TokenPair, RefreshTokenRecord, error) {
+	now := time.Now()
+	accessToken, err := r.Auth.GenerateTokenWith(sub, scopes, r.AccessTTL)
+	if err != nil {
+		return TokenPair{}, RefreshTokenRecord{}, err
+	}
+	refreshToken, err := GenerateRandomToken(32)
+	if err != nil {
+		return TokenPair{}, RefreshTokenRecord{}, fmt.Errorf("generating refresh token: %w", err)
+	}
+	record := RefreshTokenRecord{
+		Hash:      hashRefreshToken(refreshToken),
+		FamilyID:  familyID,
+		Subject:   encodedSub,
+		Scopes:    slices.Clone(scopes),
+		IssuedAt:  now,
+		ExpiresAt: expiresAt,
+	}
+	return TokenPair{
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		AccessExpiresAt:  now.Add(r.AccessTTL),
+		RefreshExpiresAt: record.ExpiresAt,
+	}, record, nil
+}
+
+// hashRefreshToken uses a plain sha256 as refresh tokens are high entropy random
+// values, unlike passwords.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// InMemoryRefreshTokenStore is a RefreshTokenStore for single instance deployments and
+// tests. Records are dropped once expired.
+type InMemoryRefreshTokenStore struct {
+	mu      sync.Mutex
+	records map[string]RefreshTokenRecord
+}
+
+func NewInMemoryRefreshTokenStore() *InMemoryRefreshTokenStore {
+	return &InMemoryRefreshTokenStore{records: map[string]RefreshTokenRecord{}}
+}
+
+func (s *InMemoryRefreshTokenStore) Save(ctx context.Context, record RefreshTokenRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteExpiredLocked(time.Now())
+	s.records[record.Hash] = record
+	return nil
+}
+
+func (s *InMemoryRefreshTokenStore) Load(ctx context.Context, hash string) (RefreshTokenRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[hash]
+	if !ok {
+		return RefreshTokenRecord{}, ErrRefreshTokenInvalid
+	}
+	return record, nil
+}
+
+func (s *InMemoryRefreshTokenStore) Rotate(ctx context.Context, usedHash string, successor RefreshTokenRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[usedHash]
+	if !ok {
+		return ErrRefreshTokenInvalid
+	}
+	if record.Revoked || time.Now().After(record.ExpiresAt) {
+		return ErrRefreshTokenInvalid
+	}
+	if record.Used {
+		return ErrRefreshTokenReused
+	}
+	record.Used = true
+	s.records[usedHash] = record
+	s.records[successor.Hash] = successor
+	return nil
+}
+
+func (s *InMemoryRefreshTokenStore) RevokeFamily(ctx context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, record := range s.records {
+		if record.FamilyID == familyID {
+			record.Revoked = true
+			s.records[hash] = record
+		}
+	}
+	return nil
+}
+
+func (s *InMemoryRefreshTokenStore) deleteExpiredLocked(now time.Time) {
+	for hash, record := range s.records {
+		if now.After(record.ExpiresAt) {
+			delete(s.records, hash)
+		}
+	}
+}
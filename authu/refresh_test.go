@@ -0,0 +1,160 @@
+package authu
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokensRotateAndDetectReuse(t *testing.T) {
+	ctx := context.Background()
+	tokens := NewRefreshTokens(newJWTTestAuth(t), NewInMemoryRefreshTokenStore())
+
+	first, err := tokens.Issue(ctx, "user-1", []string{"orders:read"})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	second, user, err := tokens.Refresh(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if user != "user-1" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("user = %v, rotated = %v", user, second.RefreshToken != first.RefreshToken)
+	}
+	claims, err := tokens.Auth.Verify(second.AccessToken, WithRequiredScopes("orders:read"))
+	if err != nil {
+		t.Fatalf("Verify access token: %v", err)
+	}
+	if claims["sub"] != "user-1" {
+		t.Fatalf("sub = %v", claims["sub"])
+	}
+
+	// replaying the first token revokes the family including the latest token
+	if _, _, err := tokens.Refresh(ctx, first.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("replay err = %v, want ErrRefreshTokenReused", err)
+	}
+	if _, _, err := tokens.Refresh(ctx, second.RefreshToken); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Fatalf("refresh after reuse err = %v, want ErrRefreshTokenInvalid", err)
+	}
+}
+
+func TestRefreshTokensRevoke(t *testing.T) {
+	ctx := context.Background()
+	tokens := NewRefreshTokens(newJWTTestAuth(t), NewInMemoryRefreshTokenStore())
+
+	pair, err := tokens.Issue(ctx, "user-1", nil)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if err := tokens.Revoke(ctx, pair.RefreshToken); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, _, err := tokens.Refresh(ctx, pair.RefreshToken); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Fatalf("refresh after revoke err = %v, want ErrRefreshTokenInvalid", err)
+	}
+	if _, _, err := tokens.Refresh(ctx, "unknown"); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Fatalf("unknown token err = %v, want ErrRefreshTokenInvalid", err)
+	}
+}
+
+type flakyRefreshTokenStore struct {
+	*InMemoryRefreshTokenStore
+	failRotate bool
+}
+
+func (s *flakyRefreshTokenStore) Rotate(ctx context.Context, usedHash string, successor RefreshTokenRecord) error {
+	if s.failRotate {
+		s.failRotate = false
+		return errors.New("store unavailable")
+	}
+	return s.InMemoryRefreshTokenStore.Rotate(ctx, usedHash, successor)
+}
+
+func TestRefreshTokensRetryAfterTransientFailure(t *testing.T) {
+	ctx := context.Background()
+	store := &flakyRefreshTokenStore{InMemoryRefreshTokenStore: NewInMemoryRefreshTokenStore()}
+	tokens := NewRefreshTokens(newJWTTestAuth(t), store)
+	pair, err := tokens.Issue(ctx, "user-1", nil)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	loadUser := tokens.Auth.UserLoader
+	tokens.Auth.UserLoader = func(sub string) (string, error) {
+		return "", errors.New("database unavailable")
+	}
+	if _, _, err := tokens.Refresh(ctx, pair.RefreshToken); err == nil {
+		t.Fatalf("expected the user loader failure")
+	}
+	tokens.Auth.UserLoader = loadUser
+
+	store.failRotate = true
+	if _, _, err := tokens.Refresh(ctx, pair.RefreshToken); err == nil {
+		t.Fatalf("expected the store failure")
+	}
+
+	next, user, err := tokens.Refresh(ctx, pair.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh retry: %v", err)
+	}
+	if user != "user-1" {
+		t.Fatalf("user = %v", user)
+	}
+	if _, _, err := tokens.Refresh(ctx, next.RefreshToken); err != nil {
+		t.Fatalf("Refresh with rotated token: %v", err)
+	}
+}
+
+// revokingRefreshTokenStore revokes the family right after a Load, as a concurrent
+// replay of an older token would.
+type revokingRefreshTokenStore struct {
+	*InMemoryRefreshTokenStore
+}
+
+func (s revokingRefreshTokenStore) Load(ctx context.Context, hash string) (RefreshTokenRecord, error) {
+	record, err := s.InMemoryRefreshTokenStore.Load(ctx, hash)
+	if err == nil {
+		err = s.RevokeFamily(ctx, record.FamilyID)
+	}
+	return record, err
+}
+
+func TestRefreshTokensRevocationBetweenLoadAndRotate(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryRefreshTokenStore()
+	tokens := NewRefreshTokens(newJWTTestAuth(t), store)
+	pair, err := tokens.Issue(ctx, "user-1", nil)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	tokens.Store = revokingRefreshTokenStore{store}
+	if _, _, err := tokens.Refresh(ctx, pair.RefreshToken); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Fatalf("Refresh of a family revoked after Load err = %v, want ErrRefreshTokenInvalid", err)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for _, record := range store.records {
+		if !record.Revoked {
+			t.Fatalf("a successor was stored for a revoked family: %#v", record)
+		}
+	}
+}
+
+func TestRefreshTokensKeepFamilyExpiry(t *testing.T) {
+	ctx := context.Background()
+	tokens := NewRefreshTokens(newJWTTestAuth(t), NewInMemoryRefreshTokenStore())
+	first, err := tokens.Issue(ctx, "user-1", nil)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	second, _, err := tokens.Refresh(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if !second.RefreshExpiresAt.Equal(first.RefreshExpiresAt) {
+		t.Fatalf("rotation moved the family expiry from %v to %v", first.RefreshExpiresAt, second.RefreshExpiresAt)
+	}
+}
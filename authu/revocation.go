@@ -0,0 +1,158 @@
+package authu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var ErrTokenRevoked = errors.New("token revoked")
+
+// RevocationStore backs JWTAuth token revocation. It holds a denylist of revoked jti
+// values, which only need keeping until the token's own exp, and a per-subject
+// watermark invalidating all tokens issued before it.
+type RevocationStore interface {
+	RevokeJTI(ctx context.Context, jti string, expiresAt time.Time) error
+	IsJTIRevoked(ctx context.Context, jti string) (bool, error)
+	RevokeSubjectBefore(ctx context.Context, sub string, before time.Time) error
+	// SubjectRevokedBefore returns the subject's watermark or the zero time when none.
+	SubjectRevokedBefore(ctx context.Context, sub string) (time.Time, error)
+}
+
+// Revoke verifies the token and adds its jti to the denylist until the token expires.
+func (a *JWTAuth[T, K]) Revoke(ctx context.Context, jwtToken string) error {
+	claims, err := a.Verify(jwtToken)
+	if err != nil {
+		return err
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return fmt.Errorf("%w 'jti'", ErrMissingClaim)
+	}
+	exp, ok, err := claimTime(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// without exp the entry has to be kept for as long as keys are accepted
+		exp = time.Now().Add(a.AutoRotateDuration + a.RetiredKeyGrace)
+	}
+	return a.RevokeJTI(ctx, jti, exp.Add(a.claims.leeway))
+}
+
+func (a *JWTAuth[T, K]) RevokeJTI(ctx context.Context, jti string, expiresAt time.Time) error {
+	if a.RevocationStore == nil {
+		return fmt.Errorf("no revocation store configured")
+	}
+	return a.RevocationStore.RevokeJTI(ctx, jti, expiresAt)
+}
+
+// RevokeSubjectTokensBefore invalidates every token for sub issued before the given
+// time, e.g. after a password change. As iat has second precision, tokens issued
+// within the same second as before are still accepted.
+func (a *JWTAuth[T, K]) RevokeSubjectTokensBefore(ctx context.Context, sub K, before time.Time) error {
+	if a.RevocationStore == nil {
+		return fmt.Errorf("no revocation store configured")
+	}
+	encodedSub, err := encodeJWTSubject(sub)
+	if err != nil {
+		return err
+	}
+	return a.RevocationStore.RevokeSubjectBefore(ctx, encodedSub, before.Truncate(time.Second))
+}
+
+func (a *JWTAuth[T, K]) checkRevoked(ctx context.Context, claims jwt.MapClaims) error {
+	if a.RevocationStore == nil {
+		return nil
+	}
+	if jti, _ := claims["jti"].(string); jti != "" {
+		revoked, err := a.RevocationStore.IsJTIRevoked(ctx, jti)
+		if err != nil {
+			return fmt.Errorf("checking token revocation: %w", err)
+		}
+		if revoked {
+			return ErrTokenRevoked
+		}
+	}
+	if sub, _ := claims["sub"].(string); sub != "" {
+		before, err := a.RevocationStore.SubjectRevokedBefore(ctx, sub)
+		if err != nil {
+			return fmt.Errorf("checking subject revocation: %w", err)
+		}
+		if before.IsZero() {
+			return nil
+		}
+		iat, ok, err := claimTime(claims, "iat")
+		if err != nil {
+			return err
+		}
+		if !ok || iat.Before(before) {
+			return ErrTokenRevoked
+		}
+	}
+	return nil
+}
+
+// InMemoryRevocationStore is a RevocationStore for single instance deployments. Revoked
+// jti entries are dropped once the token they refer to has expired.
+type InMemoryRevocationStore struct {
+	mu       sync.Mutex
+	jtis     map[string]time.Time
+	subjects map[string]time.Time
+}
+
+func NewInMemoryRevocationStore() *InMemoryRevocationStore {
+	return &InMemoryRevocationStore{
+		jtis:     map[string]time.Time{},
+		subjects: map[string]time.Time{},
+	}
+}
+
+func (s *InMemoryRevocationStore) RevokeJTI(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteExpiredLocked(time.Now())
+	s.jtis[jti] = expiresAt
+	return nil
+}
+
+func (s *InMemoryRevocationStore) IsJTIRevoked(ctx context.Context, jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiresAt, ok := s.jtis[jti]
+	if !ok {
+		return false, nil
+	}
+	if time.Now().After(expiresAt) {
+		delete(s.jtis, jti)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (s *InMemoryRevocationStore) RevokeSubjectBefore(ctx context.Context, sub string, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if before.After(s.subjects[sub]) {
+		s.subjects[sub] = before
+	}
+	return nil
+}
+
+func (s *InMemoryRevocationStore) SubjectRevokedBefore(ctx context.Context, sub string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.subjects[sub], nil
+}
+
+func (s *InMemoryRevocationStore) deleteExpiredLocked(now time.Time) {
+	for jti, expiresAt := range s.jtis {
+		if now.After(expiresAt) {
+			delete(s.jtis, jti)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package authu
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJWTAuthRevokeToken(t *testing.T) {
+	ctx := context.Background()
+	auth := newJWTTestAuth(t)
+	auth.RevocationStore = NewInMemoryRevocationStore()
+
+	revoked, err := auth.GenerateTokenWith("user-1", nil, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateTokenWith: %v", err)
+	}
+	other, err := auth.GenerateTokenWith("user-1", nil, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateTokenWith: %v", err)
+	}
+	if err := auth.Revoke(ctx, revoked); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, _, err := auth.VerifyAndResolveUser(revoked); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Verify revoked err = %v, want ErrTokenRevoked", err)
+	}
+	if _, err := auth.Verify(other); err != nil {
+		t.Fatalf("Verify other: %v", err)
+	}
+}
+
+func TestJWTAuthRevokeSubjectTokensBefore(t *testing.T) {
+	ctx := context.Background()
+	auth := newJWTTestAuth(t)
+	auth.RevocationStore = NewInMemoryRevocationStore()
+
+	old, err := auth.GenerateTokenWith("user-1", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateTokenWith: %v", err)
+	}
+	otherUser, err := auth.GenerateTokenWith("user-2", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateTokenWith: %v", err)
+	}
+	if err := auth.RevokeSubjectTokensBefore(ctx, "user-1", time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("RevokeSubjectTokensBefore: %v", err)
+	}
+	if _, err := auth.Verify(old); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Verify old err = %v, want ErrTokenRevoked", err)
+	}
+	if _, err := auth.Verify(otherUser); err != nil {
+		t.Fatalf("Verify other user: %v", err)
+	}
+
+	auth.claims.clock = func() time.Time { return time.Now().Add(2 * time.Second) }
+	fresh, err := auth.GenerateTokenWith("user-1", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateTokenWith: %v", err)
+	}
+	if _, err := auth.Verify(fresh); err != nil {
+		t.Fatalf("Verify fresh: %v", err)
+	}
+}
+
+func TestInMemoryRevocationStoreExpiresEntries(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryRevocationStore()
+	if err := store.RevokeJTI(ctx, "expired", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("RevokeJTI: %v", err)
+	}
+	if err := store.RevokeJTI(ctx, "live", time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("RevokeJTI: %v", err)
+	}
+	if revoked, _ := store.IsJTIRevoked(ctx, "expired"); revoked {
+		t.Fatalf("expired entry still revoked")
+	}
+	if revoked, _ := store.IsJTIRevoked(ctx, "live"); !revoked {
+		t.Fatalf("live entry not revoked")
+	}
+	if len(store.jtis) != 1 {
+		t.Fatalf("entries = %v, want 1", len(store.jtis))
+	}
+}
@@ -0,0 +1,287 @@
+package authu
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jptrs93/goutil/contextu"
+)
+
+var ErrNoSigningKey = errors.New("no active signing key")
+var ErrSigningKeyConflict = errors.New("active signing key changed concurrently")
+
+// SigningKeyStore persists the active private signing key so that every instance of
+// a deployment signs with the same key and restarts don't discard it. Stored keys are
+// always encrypted by JWTAuth before they reach the store.
+type SigningKeyStore interface {
+	// LoadActive returns the current active key or ErrNoSigningKey.
+	LoadActive(ctx context.Context) (SigningKeyRecord, error)
+	// SwapActive replaces the active key only if the currently stored kid equals
+	// prevKid ("" meaning no key stored). Otherwise it returns ErrSigningKeyConflict,
+	// which is how a single instance is elected to rotate.
+	SwapActive(ctx context.Context, prevKid string, record SigningKeyRecord) error
+}
+
+type SigningKeyRecord struct {
+	Kid          string    `json:"kid"`
+	Alg          string    `json:"alg"`
+	Created      time.Time `json:"created"`
+	EncryptedKey []byte    `json:"encryptedKey"`
+}
+
+// ensureSharedActiveKey loads the active key from SigningKeyStore, rotating it when it
+// has expired or was generated for a different SigningMethod. Must be called with a.mu held.
+func (a *JWTAuth[T, K]) ensureSharedActiveKey() (*activeKey, error) {
+	ctx := context.Background()
+	for attempt := 0; ; attempt++ {
+		record, err := a.SigningKeyStore.LoadActive(ctx)
+		if err != nil && !errors.Is(err, ErrNoSigningKey) {
+			return nil, fmt.Errorf("loading signing key: %w", err)
+		}
+		var prevKid string
+		if err == nil {
+			prevKid = record.Kid
+			if record.Alg == a.SigningMethod.Alg() && (a.AutoRotateDuration <= 0 || time.Since(record.Created) < a.AutoRotateDuration) {
+				return a.activateRecord(record)
+			}
+		}
+
+		// the public key is only published once this instance won the swap, so losers
+		// don't leave unused keys in the JWKS
+		ak, err := a.generateActiveKey()
+		if err != nil {
+			return nil, err
+		}
+		encrypted, err := a.encryptSigningKey(ak)
+		if err != nil {
+			return nil, err
+		}
+		err = a.SigningKeyStore.SwapActive(ctx, prevKid, SigningKeyRecord{
+			Kid:          ak.Kid,
+			Alg:          a.SigningMethod.Alg(),
+			Created:      ak.Created,
+			EncryptedKey: encrypted,
+		})
+		if err == nil {
+			// should publishing fail, the next call activates the stored record, which
+			// publishes it again
+			if err := a.publishActiveKey(ak); err != nil {
+				return nil, err
+			}
+			a.activeKey.Store(ak)
+			return ak, nil
+		}
+		// another instance rotated first, go round again and pick up its key
+		if !errors.Is(err, ErrSigningKeyConflict) || attempt >= 2 {
+			return nil, fmt.Errorf("storing signing key: %w", err)
+		}
+	}
+}
+
+func (a *JWTAuth[T, K]) activateRecord(record SigningKeyRecord) (*activeKey, error) {
+	if current := a.activeKey.Load(); current != nil && current.Kid == record.Kid {
+		refreshed := *current
+		refreshed.Loaded = time.Now()
+		a.activeKey.Store(&refreshed)
+		return &refreshed, nil
+	}
+	plain, err := a.keyCipher(func(aead cipher.AEAD) ([]byte, error) {
+		if len(record.EncryptedKey) < aead.NonceSize() {
+			return nil, fmt.Errorf("encrypted signing key too short")
+		}
+		nonce, sealed := record.EncryptedKey[:aead.NonceSize()], record.EncryptedKey[aead.NonceSize():]
+		return aead.Open(nil, nonce, sealed, []byte(record.Kid))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decrypting signing key: %w", err)
+	}
+	signingKey, verifyKey, err := parseSigningKey(plain)
+	if err != nil {
+		return nil, err
+	}
+	ak := &activeKey{
+		Created:    record.Created,
+		Loaded:     time.Now(),
+		Kid:        record.Kid,
+		SigningKey: signingKey,
+		VerifyKey:  verifyKey,
+	}
+	// publish whatever is activated, in case the instance that stored the key failed
+	// to, so no instance signs with a key verifiers can't load
+	if err := a.publishActiveKey(ak); err != nil {
+		return nil, err
+	}
+	a.activeKey.Store(ak)
+	return ak, nil
+}
+
+func (a *JWTAuth[T, K]) encryptSigningKey(ak *activeKey) ([]byte, error) {
+	plain, err := marshalSigningKey(ak.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := a.keyCipher(func(aead cipher.AEAD) ([]byte, error) {
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		// the kid is bound as additional data so records can't be swapped around
+		return aead.Seal(nonce, nonce, plain, []byte(ak.Kid)), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encrypting signing key: %w", err)
+	}
+	return encrypted, nil
+}
+
+func (a *JWTAuth[T, K]) keyCipher(f func(cipher.AEAD) ([]byte, error)) ([]byte, error) {
+	if len(a.KeyEncryptionKey) != 32 {
+		return nil, fmt.Errorf("key encryption key must be 32 bytes, got %v", len(a.KeyEncryptionKey))
+	}
+	block, err := aes.NewCipher(a.KeyEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return f(aead)
+}
+
+// FileSigningKeyStore is a SigningKeyStore keeping the active key in a directory that
+// all instances can reach, e.g. a shared volume. Rotation is serialised with an
+// exclusive lock file.
+type FileSigningKeyStore struct {
+	Dir string
+	// StaleLockAge is how old a lock file must be before it is assumed to have been
+	// left behind by a crashed instance and is broken.
+	StaleLockAge time.Duration
+}
+
+func NewFileSigningKeyStore(dir string) (*FileSigningKeyStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating signing key dir %q: %w", dir, err)
+	}
+	return &FileSigningKeyStore{Dir: dir, StaleLockAge: 30 * time.Second}, nil
+}
+
+func (s *FileSigningKeyStore) LoadActive(ctx context.Context) (SigningKeyRecord, error) {
+	b, err := os.ReadFile(s.activePath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return SigningKeyRecord{}, ErrNoSigningKey
+	}
+	if err != nil {
+		return SigningKeyRecord{}, err
+	}
+	var record SigningKeyRecord
+	if err := json.Unmarshal(b, &record); err != nil {
+		return SigningKeyRecord{}, fmt.Errorf("decoding signing key record: %w", err)
+	}
+	return record, nil
+}
+
+func (s *FileSigningKeyStore) SwapActive(ctx context.Context, prevKid string, record SigningKeyRecord) error {
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := s.LoadActive(ctx)
+	if err != nil && !errors.Is(err, ErrNoSigningKey) {
+		return err
+	}
+	if current.Kid != prevKid {
+		return ErrSigningKeyConflict
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encoding signing key record: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.Dir, "active-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.activePath())
+}
+
+// lock takes the lock file, writing a random token to it so unlock only removes its own
+// lock.
+func (s *FileSigningKeyStore) lock(ctx context.Context) (func(), error) {
+	path := filepath.Join(s.Dir, "active.lock")
+	token := rand.Text()
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_, err = f.WriteString(token)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("acquiring signing key lock: %w", err)
+			}
+			return func() {
+				if b, err := os.ReadFile(path); err == nil && string(b) == token {
+					os.Remove(path)
+				}
+			}, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("acquiring signing key lock: %w", err)
+		}
+		if info, err := os.Stat(path); err == nil && s.isStale(info) {
+			s.breakStaleLock(path)
+			continue
+		}
+		contextu.Sleep(ctx, 10*time.Millisecond)
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("acquiring signing key lock: %w", ctx.Err())
+		}
+	}
+}
+
+// breakStaleLock moves the lock file out of the way under a name of its own. Only one
+// waiter's rename can succeed, and as the lock may have been replaced by a fresh one
+// since it was seen stale, the moved file is checked again and put back if it is fresh.
+func (s *FileSigningKeyStore) breakStaleLock(path string) {
+	moved := path + "." + rand.Text() + ".stale"
+	if err := os.Rename(path, moved); err != nil {
+		return
+	}
+	if info, err := os.Stat(moved); err == nil && !s.isStale(info) {
+		// link fails rather than replace a lock taken in the meantime
+		_ = os.Link(moved, path)
+	}
+	os.Remove(moved)
+}
+
+func (s *FileSigningKeyStore) isStale(info fs.FileInfo) bool {
+	return s.StaleLockAge > 0 && time.Since(info.ModTime()) > s.StaleLockAge
+}
+
+func (s *FileSigningKeyStore) activePath() string {
+	return filepath.Join(s.Dir, "active.json")
+}
@@ -0,0 +1,224 @@
+package authu
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newSharedKeyTestAuth(t *testing.T, store SigningKeyStore, kek []byte, publicKeys *JWTAuth[string, string]) *JWTAuth[string, string] {
+	t.Helper()
+	auth := newJWTTestAuth(t)
+	auth.PublicKeyStorer = publicKeys.PublicKeyStorer
+	auth.PublicKeyLoader = publicKeys.PublicKeyLoader
+	auth.SigningKeyStore = store
+	auth.KeyEncryptionKey = kek
+	return auth
+}
+
+func TestSharedSigningKeyElectsSingleKey(t *testing.T) {
+	store, err := NewFileSigningKeyStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileSigningKeyStore: %v", err)
+	}
+	kek := make([]byte, 32)
+	_, _ = rand.Read(kek)
+	publicKeys := newJWTTestAuth(t)
+	var mu sync.Mutex
+	published := map[string]bool{}
+	storePublicKey := publicKeys.PublicKeyStorer
+	publicKeys.PublicKeyStorer = func(kid string, key []byte) error {
+		mu.Lock()
+		published[kid] = true
+		mu.Unlock()
+		return storePublicKey(kid, key)
+	}
+
+	instances := make([]*JWTAuth[string, string], 4)
+	kids := make([]string, len(instances))
+	var wg sync.WaitGroup
+	for i := range instances {
+		instances[i] = newSharedKeyTestAuth(t, store, kek, publicKeys)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ak, err := instances[i].ensureActiveKey()
+			if err != nil {
+				t.Errorf("ensureActiveKey: %v", err)
+				return
+			}
+			kids[i] = ak.Kid
+		}()
+	}
+	wg.Wait()
+	if n := len(published); n != 1 {
+		t.Fatalf("published %d public keys, want only the elected one", n)
+	}
+	for _, kid := range kids[1:] {
+		if kid != kids[0] {
+			t.Fatalf("instances disagree on active kid: %v", kids)
+		}
+	}
+
+	// a token minted by one instance verifies on another, and a restart keeps the key
+	token, err := instances[0].GenerateTokenWith("user-1", nil, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateTokenWith: %v", err)
+	}
+	restarted := newSharedKeyTestAuth(t, store, kek, publicKeys)
+	if _, err := restarted.Verify(token); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	ak, err := restarted.ensureActiveKey()
+	if err != nil {
+		t.Fatalf("ensureActiveKey: %v", err)
+	}
+	if ak.Kid != kids[0] {
+		t.Fatalf("restarted kid = %v, want %v", ak.Kid, kids[0])
+	}
+}
+
+func TestSharedSigningKeyRotationIsPickedUp(t *testing.T) {
+	store, err := NewFileSigningKeyStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileSigningKeyStore: %v", err)
+	}
+	kek := make([]byte, 32)
+	_, _ = rand.Read(kek)
+	publicKeys := newJWTTestAuth(t)
+
+	leader := newSharedKeyTestAuth(t, store, kek, publicKeys)
+	follower := newSharedKeyTestAuth(t, store, kek, publicKeys)
+	first, err := leader.ensureActiveKey()
+	if err != nil {
+		t.Fatalf("ensureActiveKey: %v", err)
+	}
+
+	leader.AutoRotateDuration = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	second, err := leader.ensureActiveKey()
+	if err != nil {
+		t.Fatalf("ensureActiveKey: %v", err)
+	}
+	if second.Kid == first.Kid {
+		t.Fatalf("expected rotation")
+	}
+
+	follower.SigningKeyRefresh = 0
+	got, err := follower.ensureActiveKey()
+	if err != nil {
+		t.Fatalf("ensureActiveKey: %v", err)
+	}
+	if got.Kid != second.Kid {
+		t.Fatalf("follower kid = %v, want %v", got.Kid, second.Kid)
+	}
+}
+
+func TestSharedSigningKeyRequiresMatchingEncryptionKey(t *testing.T) {
+	store, err := NewFileSigningKeyStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileSigningKeyStore: %v", err)
+	}
+	kek := make([]byte, 32)
+	_, _ = rand.Read(kek)
+	publicKeys := newJWTTestAuth(t)
+	if _, err := newSharedKeyTestAuth(t, store, kek, publicKeys).ensureActiveKey(); err != nil {
+		t.Fatalf("ensureActiveKey: %v", err)
+	}
+
+	wrong := make([]byte, 32)
+	if _, err := newSharedKeyTestAuth(t, store, wrong, publicKeys).ensureActiveKey(); err == nil {
+		t.Fatalf("expected decrypt failure with wrong key encryption key")
+	}
+}
+
+func TestFileSigningKeyStoreBreaksStaleLockOnce(t *testing.T) {
+	store, err := NewFileSigningKeyStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileSigningKeyStore: %v", err)
+	}
+	store.StaleLockAge = time.Minute
+	lockPath := filepath.Join(store.Dir, "active.lock")
+	if err := os.WriteFile(lockPath, []byte("crashed"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(lockPath, old, old); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+
+	var holders, maxHolders atomic.Int32
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := store.lock(context.Background())
+			if err != nil {
+				t.Errorf("lock: %v", err)
+				return
+			}
+			n := holders.Add(1)
+			for {
+				m := maxHolders.Load()
+				if n <= m || maxHolders.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			holders.Add(-1)
+			unlock()
+		}()
+	}
+	wg.Wait()
+	if got := maxHolders.Load(); got != 1 {
+		t.Fatalf("lock held by %d waiters at once", got)
+	}
+	entries, err := os.ReadDir(store.Dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected no lock files left, got %v", entries)
+	}
+}
+
+func TestSharedSigningKeyPublishedByLaterInstance(t *testing.T) {
+	store, err := NewFileSigningKeyStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileSigningKeyStore: %v", err)
+	}
+	kek := make([]byte, 32)
+	_, _ = rand.Read(kek)
+	publicKeys := newJWTTestAuth(t)
+
+	failing := newSharedKeyTestAuth(t, store, kek, publicKeys)
+	failing.PublicKeyStorer = func(kid string, key []byte) error {
+		return errors.New("storage unavailable")
+	}
+	if _, err := failing.ensureActiveKey(); err == nil {
+		t.Fatalf("expected the publish failure to be returned")
+	}
+	record, err := store.LoadActive(context.Background())
+	if err != nil {
+		t.Fatalf("LoadActive: %v", err)
+	}
+
+	other := newSharedKeyTestAuth(t, store, kek, publicKeys)
+	ak, err := other.ensureActiveKey()
+	if err != nil {
+		t.Fatalf("ensureActiveKey: %v", err)
+	}
+	if ak.Kid != record.Kid {
+		t.Fatalf("kid = %v, want the stored %v", ak.Kid, record.Kid)
+	}
+	if _, err := publicKeys.PublicKeyLoader(record.Kid); err != nil {
+		t.Fatalf("PublicKeyLoader after activation: %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package authu
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jptrs93/goutil/timeu"
+)
+
+// ThrottleState is the failure counter a ThrottleStore keeps per key.
+type ThrottleState struct {
+	Failures     int
+	LockDuration time.Duration
+	LockedUntil  time.Time
+	LastFailure  time.Time
+	// ExpiresAt is when the state can be forgotten, as it would be reset anyway.
+	ExpiresAt time.Time
+}
+
+type ThrottleStore interface {
+	// Update atomically replaces the state of key with the result of fn. A zero state
+	// may be deleted, and states may be dropped once their ExpiresAt has passed.
+	Update(ctx context.Context, key string, fn func(ThrottleState) ThrottleState) (ThrottleState, error)
+	Load(ctx context.Context, key string) (ThrottleState, error)
+}
+
+// ThrottleResult says whether a login attempt may go ahead. When it may not, handlers
+// should respond 429 with WriteThrottled.
+type ThrottleResult struct {
+	Allowed     bool
+	LockedUntil time.Time
+}
+
+func (r ThrottleResult) RetryAfter() time.Duration {
+	return max(time.Until(r.LockedUntil), 0)
+}
+
+// LoginThrottler locks out accounts and client IPs after repeated failed logins. Once
+// a key exceeds its free attempts, every further failure locks it for the next
+// duration of Backoff, which grows through Backoff.F up to Backoff.MaxDuration and
+// starts over once no failure has been seen for Backoff.ResetDuration, just like
+// timeu.Backoff.Wait.
+type LoginThrottler struct {
+	Store   ThrottleStore
+	Backoff timeu.Backoff
+	// AccountFreeAttempts and IPFreeAttempts are the failures allowed before lockouts
+	// start. IPs get more, as many users may share one behind a NAT.
+	AccountFreeAttempts int
+	IPFreeAttempts      int
+}
+
+func NewLoginThrottler(store ThrottleStore) *LoginThrottler {
+	return &LoginThrottler{
+		Store:               store,
+		Backoff:             *timeu.NewExpBackoff(15*time.Minute, time.Hour),
+		AccountFreeAttempts: 5,
+		IPFreeAttempts:      50,
+	}
+}
+
+// Check is called before verifying credentials. Empty account or ip values are skipped.
+func (t *LoginThrottler) Check(ctx context.Context, account string, ip string) (ThrottleResult, error) {
+	now := time.Now()
+	result := ThrottleResult{Allowed: true}
+	for _, key := range t.throttleKeys(account, ip) {
+		state, err := t.Store.Load(ctx, key.key)
+		if err != nil {
+			return ThrottleResult{}, fmt.Errorf("loading throttle state: %w", err)
+		}
+		if now.Before(state.LockedUntil) {
+			result.Allowed = false
+			result.LockedUntil = timeu.MaxTime(result.LockedUntil, state.LockedUntil)
+		}
+	}
+	return result, nil
+}
+
+// Failure records a failed login and returns the resulting lockout, if any.
+func (t *LoginThrottler) Failure(ctx context.Context, account string, ip string) (ThrottleResult, error) {
+	now := time.Now()
+	result := ThrottleResult{Allowed: true}
+	for _, key := range t.throttleKeys(account, ip) {
+		state, err := t.Store.Update(ctx, key.key, func(state ThrottleState) ThrottleState {
+			return t.fail(state, key.freeAttempts, now)
+		})
+		if err != nil {
+			return ThrottleResult{}, fmt.Errorf("updating throttle state: %w", err)
+		}
+		if now.Before(state.LockedUntil) {
+			result.Allowed = false
+			result.LockedUntil = timeu.MaxTime(result.LockedUntil, state.LockedUntil)
+		}
+	}
+	return result, nil
+}
+
+// Success resets the account's counter. The IP counter is left alone, otherwise an
+// attacker could clear it by logging into an account of their own.
+func (t *LoginThrottler) Success(ctx context.Context, account string) error {
+	if account == "" {
+		return nil
+	}
+	_, err := t.Store.Update(ctx, accountThrottleKey(account), func(ThrottleState) ThrottleState { return ThrottleState{} })
+	return err
+}
+
+func (t *LoginThrottler) fail(state ThrottleState, freeAttempts int, now time.Time) ThrottleState {
+	if t.Backoff.ResetDuration > 0 && !state.LastFailure.IsZero() && now.Sub(state.LastFailure) > t.Backoff.ResetDuration {
+		state = ThrottleState{}
+	}
+	state.Failures++
+	state.LastFailure = now
+	if state.Failures > freeAttempts {
+		state.LockDuration = t.Backoff.F(state.LockDuration)
+		if t.Backoff.MaxDuration > 0 && state.LockDuration > t.Backoff.MaxDuration {
+			state.LockDuration = t.Backoff.MaxDuration
+		}
+		state.LockedUntil = now.Add(state.LockDuration)
+	}
+	state.ExpiresAt = time.Time{}
+	if t.Backoff.ResetDuration > 0 {
+		state.ExpiresAt = timeu.MaxTime(now.Add(t.Backoff.ResetDuration), state.LockedUntil)
+	}
+	return state
+}
+
+type throttleKey struct {
+	key          string
+	freeAttempts int
+}
+
+func (t *LoginThrottler) throttleKeys(account string, ip string) []throttleKey {
+	var keys []throttleKey
+	if account != "" {
+		keys = append(keys, throttleKey{key: accountThrottleKey(account), freeAttempts: t.AccountFreeAttempts})
+	}
+	if ip != "" {
+		keys = append(keys, throttleKey{key: "ip:" + ip, freeAttempts: t.IPFreeAttempts})
+	}
+	return keys
+}
+
+func accountThrottleKey(account string) string {
+	return "account:" + account
+}
+
+// WriteThrottled responds 429 with a Retry-After header in whole seconds.
+func WriteThrottled(w http.ResponseWriter, result ThrottleResult) {
+	retryAfter := int(math.Ceil(result.RetryAfter().Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
+
+// InMemoryThrottleStore is a ThrottleStore for single instance deployments.
+type InMemoryThrottleStore struct {
+	mu     sync.Mutex
+	states map[string]ThrottleState
+}
+
+func NewInMemoryThrottleStore() *InMemoryThrottleStore {
+	return &InMemoryThrottleStore{states: map[string]ThrottleState{}}
+}
+
+func (s *InMemoryThrottleStore) Update(ctx context.Context, key string, fn func(ThrottleState) ThrottleState) (ThrottleState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteExpiredLocked(time.Now())
+	state := fn(s.states[key])
+	if state == (ThrottleState{}) {
+		delete(s.states, key)
+		return state, nil
+	}
+	s.states[key] = state
+	return state, nil
+}
+
+func (s *InMemoryThrottleStore) Load(ctx context.Context, key string) (ThrottleState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.states[key]
+	if isThrottleStateExpired(state, time.Now()) {
+		return ThrottleState{}, nil
+	}
+	return state, nil
+}
+
+func (s *InMemoryThrottleStore) deleteExpiredLocked(now time.Time) {
+	for key, state := range s.states {
+		if isThrottleStateExpired(state, now) {
+			delete(s.states, key)
+		}
+	}
+}
+
+func isThrottleStateExpired(state ThrottleState, now time.Time) bool {
+	return !state.ExpiresAt.IsZero() && now.After(state.ExpiresAt)
+}
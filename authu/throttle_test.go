@@ -0,0 +1,73 @@
+package authu
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottlerLocksOutWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	throttler := NewLoginThrottler(NewInMemoryThrottleStore())
+	throttler.AccountFreeAttempts = 2
+
+	for i := range 2 {
+		result, err := throttler.Failure(ctx, "alice", "10.0.0.1")
+		if err != nil || !result.Allowed {
+			t.Fatalf("failure %d = %+v, %v", i, result, err)
+		}
+	}
+	result, err := throttler.Failure(ctx, "alice", "10.0.0.1")
+	if err != nil || result.Allowed {
+		t.Fatalf("third failure = %+v, %v", result, err)
+	}
+	if d := result.RetryAfter(); d <= time.Second || d > 2*time.Second {
+		t.Fatalf("first lockout = %v, want 2s", d)
+	}
+	if result, _ := throttler.Check(ctx, "alice", ""); result.Allowed {
+		t.Fatalf("Check locked account allowed")
+	}
+	// the ip is not locked and other accounts are unaffected
+	if result, _ := throttler.Check(ctx, "bob", "10.0.0.1"); !result.Allowed {
+		t.Fatalf("Check other account = %+v", result)
+	}
+
+	result, _ = throttler.Failure(ctx, "alice", "")
+	if d := result.RetryAfter(); d <= 3*time.Second || d > 4*time.Second {
+		t.Fatalf("second lockout = %v, want 4s", d)
+	}
+
+	if err := throttler.Success(ctx, "alice"); err != nil {
+		t.Fatalf("Success: %v", err)
+	}
+	if result, _ := throttler.Check(ctx, "alice", "10.0.0.1"); !result.Allowed {
+		t.Fatalf("Check after success = %+v", result)
+	}
+}
+
+func TestLoginThrottlerBackoffResetAndCap(t *testing.T) {
+	throttler := NewLoginThrottler(NewInMemoryThrottleStore())
+	throttler.Backoff.MaxDuration = 5 * time.Second
+	now := time.Now()
+	var state ThrottleState
+	for range 5 {
+		state = throttler.fail(state, 0, now)
+	}
+	if state.LockDuration != 5*time.Second {
+		t.Fatalf("lock duration = %v, want capped 5s", state.LockDuration)
+	}
+	state = throttler.fail(state, 0, now.Add(throttler.Backoff.ResetDuration+time.Second))
+	if state.Failures != 1 || state.LockDuration != 2*time.Second {
+		t.Fatalf("state after reset = %+v", state)
+	}
+}
+
+func TestWriteThrottled(t *testing.T) {
+	rec := httptest.NewRecorder()
+	WriteThrottled(rec, ThrottleResult{LockedUntil: time.Now().Add(90 * time.Second)})
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "90" {
+		t.Fatalf("response = %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
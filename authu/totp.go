@@ -0,0 +1,263 @@
+package authu
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrTOTPCodeReused = errors.New("totp code already used")
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTP generates and verifies RFC 6238 time based one-time passwords. Secrets are
+// base32 encoded, as expected by authenticator apps.
+type TOTP struct {
+	Issuer string
+	// Algorithm is SHA1, SHA256 or SHA512. Most authenticator apps only support SHA1.
+	Algorithm string
+	Digits    int
+	Period    time.Duration
+	// Skew is the number of periods either side of the current one that are accepted,
+	// allowing for clock drift and slow typing.
+	Skew int
+	// Used enables replay protection, rejecting codes at or before the last accepted
+	// time step of the same subject.
+	Used  TOTPUsedStore
+	clock func() time.Time
+}
+
+// TOTPUsedStore remembers the last accepted time step of each subject.
+type TOTPUsedStore interface {
+	// MarkUsed atomically records step as used, returning false if step is not after
+	// the subject's last used step.
+	MarkUsed(ctx context.Context, subject string, step int64) (bool, error)
+}
+
+func NewTOTP(issuer string) *TOTP {
+	return &TOTP{
+		Issuer:    issuer,
+		Algorithm: "SHA1",
+		Digits:    6,
+		Period:    30 * time.Second,
+		Skew:      1,
+		Used:      NewInMemoryTOTPUsedStore(),
+	}
+}
+
+// GenerateTOTPSecret returns a random 160 bit base32 secret, the size RFC 4226
+// recommends.
+func GenerateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// ProvisioningURI returns the otpauth:// URI to show as a QR code when enrolling. The
+// parameters are not checked, so a misconfigured TOTP gives a URI that Code and Verify
+// will refuse.
+func (t *TOTP) ProvisioningURI(secret string, accountName string) string {
+	label := url.PathEscape(accountName)
+	if t.Issuer != "" {
+		label = url.PathEscape(t.Issuer) + ":" + label
+	}
+	q := url.Values{}
+	q.Set("secret", secret)
+	if t.Issuer != "" {
+		q.Set("issuer", t.Issuer)
+	}
+	q.Set("algorithm", t.Algorithm)
+	q.Set("digits", strconv.Itoa(t.Digits))
+	q.Set("period", strconv.Itoa(int(t.Period/time.Second)))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// Code returns the code for the time step containing at.
+func (t *TOTP) Code(secret string, at time.Time) (string, error) {
+	if err := t.validate(); err != nil {
+		return "", err
+	}
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return t.code(key, t.step(at))
+}
+
+// Verify checks code against the time steps within Skew of now. With Used set, a code
+// that was already accepted for subject fails with ErrTOTPCodeReused.
+func (t *TOTP) Verify(ctx context.Context, subject string, secret string, code string) (bool, error) {
+	if err := t.validate(); err != nil {
+		return false, err
+	}
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return false, err
+	}
+	code = strings.ReplaceAll(code, " ", "")
+	current := t.step(t.now())
+	for offset := -t.Skew; offset <= t.Skew; offset++ {
+		step := current + int64(offset)
+		expected, err := t.code(key, step)
+		if err != nil {
+			return false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) != 1 {
+			continue
+		}
+		if t.Used == nil {
+			return true, nil
+		}
+		fresh, err := t.Used.MarkUsed(ctx, subject, step)
+		if err != nil {
+			return false, fmt.Errorf("recording totp use: %w", err)
+		}
+		if !fresh {
+			return false, ErrTOTPCodeReused
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func (t *TOTP) validate() error {
+	if t.Digits < 6 || t.Digits > 10 {
+		return fmt.Errorf("unsupported totp digits %d", t.Digits)
+	}
+	if t.Period < time.Second {
+		return fmt.Errorf("totp period %v is below one second", t.Period)
+	}
+	if t.Skew < 0 {
+		return fmt.Errorf("negative totp skew %d", t.Skew)
+	}
+	return nil
+}
+
+func (t *TOTP) code(key []byte, step int64) (string, error) {
+	newHash, err := totpHash(t.Algorithm)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(newHash, key)
+	_ = binary.Write(mac, binary.BigEndian, uint64(step))
+	sum := mac.Sum(nil)
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := uint64(binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff)
+	mod := uint64(1)
+	for range t.Digits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", t.Digits, value%mod), nil
+}
+
+func (t *TOTP) step(at time.Time) int64 {
+	return at.Unix() / int64(t.Period/time.Second)
+}
+
+func (t *TOTP) now() time.Time {
+	if t.clock != nil {
+		return t.clock()
+	}
+	return time.Now()
+}
+
+func totpHash(algorithm string) (func() hash.Hash, error) {
+	switch strings.ToUpper(algorithm) {
+	case "SHA1", "":
+		return sha1.New, nil
+	case "SHA256":
+		return sha256.New, nil
+	case "SHA512":
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unsupported totp algorithm %q", algorithm)
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.TrimRight(strings.ReplaceAll(secret, " ", ""), "="))
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("decoding totp secret: %w", err)
+	}
+	return key, nil
+}
+
+// InMemoryTOTPUsedStore is a TOTPUsedStore for single instance deployments.
+type InMemoryTOTPUsedStore struct {
+	mu    sync.Mutex
+	steps map[string]int64
+}
+
+func NewInMemoryTOTPUsedStore() *InMemoryTOTPUsedStore {
+	return &InMemoryTOTPUsedStore{steps: map[string]int64{}}
+}
+
+func (s *InMemoryTOTPUsedStore) MarkUsed(ctx context.Context, subject string, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if last, ok := s.steps[subject]; ok && step <= last {
+		return false, nil
+	}
+	s.steps[subject] = step
+	return true, nil
+}
+
+// GenerateRecoveryCodes returns n one-time codes to show the user once, and their
+// HashPassword hashes to store in their place.
+func GenerateRecoveryCodes(n int) (codes []string, hashes []string, err error) {
+	for range n {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		code = code[:8] + "-" + code[8:]
+		hash, err := HashPassword(normalizeRecoveryCode(code))
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hash)
+	}
+	return codes, hashes, nil
+}
+
+// VerifyRecoveryCode returns the index of the hash matching code, or -1 when none
+// does. Callers must remove the matched hash so the code can't be used again. Each
+// hash is an argon2 verification, so run this through a PasswordHasher when exposed to
+// untrusted traffic.
+func VerifyRecoveryCode(code string, hashes []string) (int, error) {
+	code = normalizeRecoveryCode(code)
+	for i, hash := range hashes {
+		ok, err := VerifyPassword(code, hash)
+		if err != nil {
+			return -1, err
+		}
+		if ok {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes so codes survive being retyped.
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+}
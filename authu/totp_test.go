@@ -0,0 +1,116 @@
+package authu
+
+import (
+	"context"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPRFC6238Vectors(t *testing.T) {
+	secrets := map[string]string{
+		"SHA1":   "12345678901234567890",
+		"SHA256": "12345678901234567890123456789012",
+		"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	vectors := []struct {
+		unix int64
+		alg  string
+		code string
+	}{
+		{59, "SHA1", "94287082"}, {59, "SHA256", "46119246"}, {59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"}, {1111111109, "SHA256", "68084774"}, {1111111109, "SHA512", "25091201"},
+		{1111111111, "SHA1", "14050471"}, {1111111111, "SHA256", "67062674"}, {1111111111, "SHA512", "99943326"},
+		{1234567890, "SHA1", "89005924"}, {1234567890, "SHA256", "91819424"}, {1234567890, "SHA512", "93441116"},
+		{2000000000, "SHA1", "69279037"}, {2000000000, "SHA256", "90698825"}, {2000000000, "SHA512", "38618901"},
+		{20000000000, "SHA1", "65353130"}, {20000000000, "SHA256", "77737706"}, {20000000000, "SHA512", "47863826"},
+	}
+	for _, v := range vectors {
+		totp := NewTOTP("")
+		totp.Algorithm = v.alg
+		totp.Digits = 8
+		secret := base32.StdEncoding.EncodeToString([]byte(secrets[v.alg]))
+		code, err := totp.Code(secret, time.Unix(v.unix, 0))
+		if err != nil {
+			t.Fatalf("Code: %v", err)
+		}
+		if code != v.code {
+			t.Fatalf("%s at %d = %s, want %s", v.alg, v.unix, code, v.code)
+		}
+	}
+}
+
+func TestTOTPVerifySkewAndReplay(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret: %v", err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	totp := NewTOTP("Example")
+	totp.clock = func() time.Time { return now }
+	ctx := context.Background()
+
+	previous, _ := totp.Code(secret, now.Add(-30*time.Second))
+	if ok, err := totp.Verify(ctx, "alice", secret, previous); err != nil || !ok {
+		t.Fatalf("Verify previous step = %v, %v", ok, err)
+	}
+	current, _ := totp.Code(secret, now)
+	if ok, err := totp.Verify(ctx, "alice", secret, current); err != nil || !ok {
+		t.Fatalf("Verify current step = %v, %v", ok, err)
+	}
+	if _, err := totp.Verify(ctx, "alice", secret, current); !errors.Is(err, ErrTOTPCodeReused) {
+		t.Fatalf("replayed Verify err = %v, want ErrTOTPCodeReused", err)
+	}
+	// steps are tracked per subject
+	if ok, err := totp.Verify(ctx, "bob", secret, current); err != nil || !ok {
+		t.Fatalf("Verify other subject = %v, %v", ok, err)
+	}
+	tooOld, _ := totp.Code(secret, now.Add(-90*time.Second))
+	if ok, _ := totp.Verify(ctx, "carol", secret, tooOld); ok {
+		t.Fatalf("Verify outside skew = true")
+	}
+}
+
+func TestTOTPRejectsInvalidConfig(t *testing.T) {
+	secret := "JBSWY3DPEHPK3PXP"
+	for name, totp := range map[string]*TOTP{
+		"zero value":      {Digits: 6},
+		"sub-second":      {Digits: 6, Period: 500 * time.Millisecond},
+		"negative skew":   {Digits: 6, Period: 30 * time.Second, Skew: -1},
+		"too many digits": {Digits: 11, Period: 30 * time.Second},
+	} {
+		if _, err := totp.Code(secret, time.Now()); err == nil {
+			t.Fatalf("%s: Code err = nil", name)
+		}
+		if _, err := totp.Verify(context.Background(), "alice", secret, "123456"); err == nil {
+			t.Fatalf("%s: Verify err = nil", name)
+		}
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := NewTOTP("Example Co").ProvisioningURI("JBSWY3DPEHPK3PXP", "alice@example.com")
+	want := "otpauth://totp/Example%20Co:alice@example.com?algorithm=SHA1&digits=6&issuer=Example+Co&period=30&secret=JBSWY3DPEHPK3PXP"
+	if uri != want {
+		t.Fatalf("uri = %s\nwant  %s", uri, want)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes(2)
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes: %v", err)
+	}
+	if len(codes) != 2 || len(hashes) != 2 || strings.Contains(hashes[1], codes[1]) {
+		t.Fatalf("codes = %v", codes)
+	}
+	i, err := VerifyRecoveryCode(" "+strings.ToUpper(codes[1])+" ", hashes)
+	if err != nil || i != 1 {
+		t.Fatalf("VerifyRecoveryCode = %d, %v", i, err)
+	}
+	if i, _ := VerifyRecoveryCode("aaaaaaaa-aaaaaaaa", hashes); i != -1 {
+		t.Fatalf("VerifyRecoveryCode unknown = %d", i)
+	}
+}
@@ -0,0 +1,176 @@
+// Package authzu makes authorization decisions from the scopes and roles carried in
+// authu token claims.
+//
+// Scopes are colon separated paths such as "orders:read". A trailing "*" segment
+// grants everything below it, so "orders:*" grants "orders:read" and
+// "orders:items:write", and "*" alone grants every scope. A "*" elsewhere matches any
+// single segment.
+package authzu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/jptrs93/goutil/authu"
+	"github.com/jptrs93/goutil/logu"
+)
+
+var ErrUnauthenticated = errors.New("unauthenticated")
+var ErrForbidden = errors.New("forbidden")
+
+// DefaultPolicy is used by the package level Require and RequireOwner.
+var DefaultPolicy = NewPolicy(nil)
+
+// Policy grants the scopes in a token's scopes claim plus those of the roles in its
+// roles claim.
+type Policy struct {
+	// Roles maps role names to the scopes they grant, which may use wildcards.
+	Roles map[string][]string
+	// Logger receives a decision log entry for every denied request.
+	Logger *slog.Logger
+}
+
+func NewPolicy(roles map[string][]string) *Policy {
+	return &Policy{Roles: roles}
+}
+
+func Require(ctx context.Context, scopes ...string) error {
+	return DefaultPolicy.Require(ctx, scopes...)
+}
+
+func RequireOwner(ctx context.Context, ownerSub string, overrideScope string) error {
+	return DefaultPolicy.RequireOwner(ctx, ownerSub, overrideScope)
+}
+
+// ScopeMatches reports whether the granted scope covers the required one.
+func ScopeMatches(granted string, required string) bool {
+	g := strings.Split(granted, ":")
+	r := strings.Split(required, ":")
+	for i, segment := range g {
+		if segment == "*" && i == len(g)-1 {
+			return i < len(r)
+		}
+		// a wildcard within the scope matches exactly one segment
+		if i >= len(r) || (segment != "*" && segment != r[i]) {
+			return false
+		}
+	}
+	return len(g) == len(r)
+}
+
+func HasScope(granted []string, required string) bool {
+	return slices.ContainsFunc(granted, func(g string) bool { return ScopeMatches(g, required) })
+}
+
+// Scopes returns everything the claims grant, with roles expanded.
+func (p *Policy) Scopes(claims jwt.MapClaims) []string {
+	scopes := claimStrings(claims["scopes"])
+	for _, role := range claimStrings(claims["roles"]) {
+		scopes = append(scopes, p.Roles[role]...)
+	}
+	return scopes
+}
+
+// Allowed reports whether the claims grant every required scope.
+func (p *Policy) Allowed(claims jwt.MapClaims, scopes ...string) bool {
+	granted := p.Scopes(claims)
+	for _, required := range scopes {
+		if !HasScope(granted, required) {
+			return false
+		}
+	}
+	return true
+}
+
+// Require checks that the claims authu middleware placed in ctx grant every given
+// scope, returning ErrUnauthenticated without claims and ErrForbidden otherwise.
+func (p *Policy) Require(ctx context.Context, scopes ...string) error {
+	claims, ok := authu.ClaimsFromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	granted := p.Scopes(claims)
+	for _, required := range scopes {
+		if !HasScope(granted, required) {
+			return p.deny(ctx, claims, fmt.Sprintf("missing scope '%v'", required))
+		}
+	}
+	return nil
+}
+
+// RequireOwner allows the subject owning a resource, or anyone granted overrideScope
+// such as "orders:admin". An empty overrideScope allows only the owner.
+func (p *Policy) RequireOwner(ctx context.Context, ownerSub string, overrideScope string) error {
+	claims, ok := authu.ClaimsFromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	if sub, _ := claims["sub"].(string); sub != "" && sub == ownerSub {
+		return nil
+	}
+	if overrideScope != "" && HasScope(p.Scopes(claims), overrideScope) {
+		return nil
+	}
+	return p.deny(ctx, claims, fmt.Sprintf("not owner '%v'", ownerSub))
+}
+
+// Middleware responds 401 or 403 unless the request's claims grant every scope. It
+// must be mounted behind authu.AuthMiddleware.
+func (p *Policy) Middleware(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := p.Require(r.Context(), scopes...); err != nil {
+				WriteError(w, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WriteError responds 401 for ErrUnauthenticated and 403 for anything else.
+func WriteError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrUnauthenticated) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
+
+func (p *Policy) deny(ctx context.Context, claims jwt.MapClaims, reason string) error {
+	logger := p.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	// the subject goes into the log context so logu handlers print it like other
+	// request metadata
+	sub, _ := claims["sub"].(string)
+	ctx = logu.ExtendLogContext(ctx, "sub", sub)
+	logger.WarnContext(ctx, "authorization denied", "reason", reason, "granted", p.Scopes(claims))
+	return fmt.Errorf("%w: %v", ErrForbidden, reason)
+}
+
+func claimStrings(v any) []string {
+	switch vv := v.(type) {
+	case string:
+		return []string{vv}
+	case []string:
+		return slices.Clone(vv)
+	case []any:
+		out := make([]string, 0, len(vv))
+		for _, item := range vv {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package authzu
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/jptrs93/goutil/authu"
+	"github.com/jptrs93/goutil/logu"
+)
+
+func TestScopeMatches(t *testing.T) {
+	cases := []struct {
+		granted, required string
+		want              bool
+	}{
+		{"orders:read", "orders:read", true},
+		{"orders:read", "orders:write", false},
+		{"orders:*", "orders:write", true},
+		{"orders:*", "orders:items:write", true},
+		{"orders:*", "orders", false},
+		{"orders", "orders:read", false},
+		{"*", "billing:read", true},
+		{"orders:*:read", "orders:items:read", true},
+		{"orders:*:read", "orders:items:write", false},
+		{"order:*", "orders:read", false},
+	}
+	for _, c := range cases {
+		if got := ScopeMatches(c.granted, c.required); got != c.want {
+			t.Errorf("ScopeMatches(%q, %q) = %v, want %v", c.granted, c.required, got, c.want)
+		}
+	}
+}
+
+func TestPolicyRequire(t *testing.T) {
+	var logs bytes.Buffer
+	policy := NewPolicy(map[string][]string{"support": {"orders:read", "users:*"}})
+	policy.Logger = slog.New(&logu.PlainLogHandler{Writer: &logs, Level: slog.LevelInfo})
+
+	if err := policy.Require(context.Background(), "orders:read"); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("Require without claims err = %v", err)
+	}
+	claims := jwt.MapClaims{"sub": "alice", "scopes": []any{"billing:read"}, "roles": []any{"support"}}
+	ctx := authu.ContextWithAuth(context.Background(), "alice", claims)
+	if err := policy.Require(ctx, "orders:read", "users:delete", "billing:read"); err != nil {
+		t.Fatalf("Require: %v", err)
+	}
+	if logs.Len() != 0 {
+		t.Fatalf("allowed request logged: %s", logs.String())
+	}
+	if err := policy.Require(ctx, "orders:write"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("Require missing scope err = %v", err)
+	}
+	if !strings.Contains(logs.String(), "authorization denied") || !strings.Contains(logs.String(), "orders:write") || !strings.Contains(logs.String(), "[sub=alice]") {
+		t.Fatalf("decision log = %s", logs.String())
+	}
+}
+
+func TestPolicyRequireOwner(t *testing.T) {
+	policy := NewPolicy(map[string][]string{"admin": {"*"}})
+	alice := authu.ContextWithAuth(context.Background(), "alice", jwt.MapClaims{"sub": "alice"})
+	admin := authu.ContextWithAuth(context.Background(), "root", jwt.MapClaims{"sub": "root", "roles": "admin"})
+	policy.Logger = slog.New(slog.DiscardHandler)
+
+	if err := policy.RequireOwner(alice, "alice", "orders:admin"); err != nil {
+		t.Fatalf("owner: %v", err)
+	}
+	if err := policy.RequireOwner(alice, "bob", "orders:admin"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("non owner err = %v", err)
+	}
+	if err := policy.RequireOwner(admin, "bob", "orders:admin"); err != nil {
+		t.Fatalf("override scope: %v", err)
+	}
+	if err := policy.RequireOwner(admin, "bob", ""); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("owner only err = %v", err)
+	}
+}
+
+func TestPolicyMiddleware(t *testing.T) {
+	policy := NewPolicy(nil)
+	policy.Logger = slog.New(slog.DiscardHandler)
+	handler := policy.Middleware("orders:write")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, c := range []struct {
+		claims jwt.MapClaims
+		want   int
+	}{
+		{nil, http.StatusUnauthorized},
+		{jwt.MapClaims{"sub": "alice", "scopes": []any{"orders:read"}}, http.StatusForbidden},
+		{jwt.MapClaims{"sub": "alice", "scopes": []any{"orders:*"}}, http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/orders", nil)
+		if c.claims != nil {
+			req = req.WithContext(authu.ContextWithAuth(req.Context(), "alice", c.claims))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Fatalf("claims %v status = %d, want %d", c.claims, rec.Code, c.want)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package logu
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes Handle wait for space in the queue.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest drops records arriving while the queue is full.
+	OverflowDropNewest
+	// OverflowDropLowLevels drops DEBUG and INFO records once the queue is three quarters
+	// full, keeping the rest of the queue for WARN and ERROR records, which block.
+	OverflowDropLowLevels
+)
+
+type AsyncHandlerOption func(*asyncConfig)
+
+type asyncConfig struct {
+	queueSize     int
+	overflow      OverflowPolicy
+	flushInterval time.Duration
+	flushLevel    slog.Level
+	flusher       Flusher
+}
+
+// Flusher is implemented by buffered writers such as BufferedWriter.
+type Flusher interface {
+	Flush() error
+}
+
+func WithQueueSize(size int) AsyncHandlerOption {
+	return func(c *asyncConfig) {
+		c.queueSize = size
+	}
+}
+
+func WithOverflowPolicy(policy OverflowPolicy) AsyncHandlerOption {
+	return func(c *asyncConfig) {
+		c.overflow = policy
+	}
+}
+
+// WithFlusher sets the buffered writer of the wrapped handler, flushed every flush
+// interval and after each record at or above the flush level.
+func WithFlusher(flusher Flusher, interval time.Duration, level slog.Level) AsyncHandlerOption {
+	return func(c *asyncConfig) {
+		c.flusher = flusher
+		c.flushInterval = interval
+		c.flushLevel = level
+	}
+}
+
+// AsyncHandler hands records to a background goroutine that passes them on to the
+// wrapped handler, so a slow disk or a blocked stdout pipe doesn't stall the logging
+// goroutines. Records dropped by the OverflowPolicy are counted, and the counts are
+// logged as a warning through the wrapped handler on each flush interval and on Close.
+//
+// Close must be called on shutdown to drain the queue. Records handled after Close are
+// passed on synchronously once the queue is drained, blocking until then if Close
+// returned early on its context.
+type AsyncHandler struct {
+	handler slog.Handler
+	state   *asyncState
+}
+
+type asyncState struct {
+	config asyncConfig
+	root   slog.Handler
+	queue  chan asyncRecord
+	stop   chan struct{}
+	done   chan struct{}
+	// mu is held for reading while enqueueing, so no record is queued after the
+	// background goroutine started draining
+	mu      sync.RWMutex
+	closed  bool
+	once    sync.Once
+	dropped [4]atomic.Uint64
+	// reported is only used by the background goroutine
+	reported [4]uint64
+}
+
+type asyncRecord struct {
+	ctx     context.Context
+	handler slog.Handler
+	record  slog.Record
+}
+
+// NewAsyncHandler queues up to 1024 records, blocking when full, and flushes once a
+// second and on ERROR records when WithFlusher is given.
+func NewAsyncHandler(handler slog.Handler, opts ...AsyncHandlerOption) *AsyncHandler {
+	config := asyncConfig{
+		queueSize:     1024,
+		flushInterval: time.Second,
+		flushLevel:    slog.LevelError,
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.flushInterval <= 0 {
+		config.flushInterval = time.Second
+	}
+	state := &asyncState{
+		config: config,
+		root:   handler,
+		queue:  make(chan asyncRecord, max(config.queueSize, 1)),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go state.run()
+	return &AsyncHandler{handler: handler, state: state}
+}
+
+func (h *AsyncHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *AsyncHandler) Handle(ctx context.Context, r slog.Record) error {
+	s := h.state
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		// wait for the queue to be drained, in case Close gave up early, so records stay
+		// in order and aren't written concurrently with the background goroutine
+		<-s.done
+		err := h.handler.Handle(ctx, r)
+		s.flush()
+		return err
+	}
+	// the record is handled after the caller returned, so it must not share attrs with
+	// the caller and must not see its context cancelled
+	rec := asyncRecord{ctx: context.WithoutCancel(ctx), handler: h.handler, record: r.Clone()}
+	switch s.config.overflow {
+	case OverflowDropNewest:
+		select {
+		case s.queue <- rec:
+		default:
+			s.dropped[levelIndex(r.Level)].Add(1)
+		}
+		return nil
+	case OverflowDropLowLevels:
+		// at least one slot is open to low levels, which small queues would round away
+		if r.Level < slog.LevelWarn && len(s.queue) >= max(cap(s.queue)*3/4, 1) {
+			s.dropped[levelIndex(r.Level)].Add(1)
+			return nil
+		}
+	}
+	s.queue <- rec
+	return nil
+}
+
+func (h *AsyncHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &AsyncHandler{handler: h.handler.WithAttrs(attrs), state: h.state}
+}
+
+func (h *AsyncHandler) WithGroup(name string) slog.Handler {
+	return &AsyncHandler{handler: h.handler.WithGroup(name), state: h.state}
+}
+
+// Dropped returns the number of records dropped so far.
+func (h *AsyncHandler) Dropped() uint64 {
+	var total uint64
+	for i := range h.state.dropped {
+		total += h.state.dropped[i].Load()
+	}
+	return total
+}
+
+// Close stops accepting records, then waits until the queued ones are handled and the
+// writer flushed, or ctx is done. It closes the handlers derived with WithAttrs and
+// WithGroup as well.
+func (h *AsyncHandler) Close(ctx context.Context) error {
+	s := h.state
+	s.once.Do(func() {
+		// taking the lock waits for Handle calls blocked on a full queue
+		go func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.closed = true
+			close(s.stop)
+		}()
+	})
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *asyncState) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.config.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case rec := <-s.queue:
+			s.handle(rec)
+		case <-ticker.C:
+			s.reportDropped()
+			s.flush()
+		case <-s.stop:
+			for {
+				select {
+				case rec := <-s.queue:
+					s.handle(rec)
+				default:
+					s.reportDropped()
+					s.flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *asyncState) handle(rec asyncRecord) {
+	// there is no caller left to return the error to
+	_ = rec.handler.Handle(rec.ctx, rec.record)
+	if rec.record.Level >= s.config.flushLevel {
+		s.flush()
+	}
+}
+
+func (s *asyncState) flush() {
+	if s.config.flusher != nil {
+		_ = s.config.flusher.Flush()
+	}
+}
+
+// reportDropped logs the number of records dropped since the last report through the
+// wrapped handler.
+func (s *asyncState) reportDropped() {
+	var counts [4]uint64
+	var total uint64
+	for i := range s.dropped {
+		counts[i] = s.dropped[i].Load() - s.reported[i]
+		s.reported[i] += counts[i]
+		total += counts[i]
+	}
+	if total == 0 {
+		return
+	}
+	r := slog.NewRecord(time.Now(), slog.LevelWarn, "dropped log records", 0)
+	r.AddAttrs(slog.Uint64("dropped", total), slog.Uint64("debug", counts[0]), slog.Uint64("info", counts[1]),
+		slog.Uint64("warn", counts[2]), slog.Uint64("error", counts[3]))
+	_ = s.root.Handle(context.Background(), r)
+}
+
+func levelIndex(level slog.Level) int {
+	switch {
+	case level < slog.LevelInfo:
+		return 0
+	case level < slog.LevelWarn:
+		return 1
+	case level < slog.LevelError:
+		return 2
+	}
+	return 3
+}
+
+// BufferedWriter is a goroutine safe buffered writer, to batch the writes of a handler
+// wrapped by AsyncHandler.
+type BufferedWriter struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func NewBufferedWriter(w io.Writer, size int) *BufferedWriter {
+	return &BufferedWriter{w: bufio.NewWriterSize(w, size)}
+}
+
+func (b *BufferedWriter) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.w.Write(p)
+}
+
+func (b *BufferedWriter) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.w.Flush()
+}
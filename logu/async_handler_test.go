@@ -0,0 +1,218 @@
+package logu
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedWriter blocks writes until the gate is opened, like a stalled stdout pipe.
+type gatedWriter struct {
+	gate chan struct{}
+	mu   sync.Mutex
+	buf  bytes.Buffer
+}
+
+func newGatedWriter() *gatedWriter {
+	return &gatedWriter{gate: make(chan struct{})}
+}
+
+func (w *gatedWriter) Write(p []byte) (int, error) {
+	<-w.gate
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *gatedWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestAsyncHandlerDrainsOnClose(t *testing.T) {
+	w := newGatedWriter()
+	h := NewAsyncHandler(&PlainLogHandler{Writer: w, Level: slog.LevelDebug})
+	logger := slog.New(h).With("service", "api").WithGroup("http")
+	ctx := ExtendLogContext(context.Background(), "request_id", "req-1")
+
+	for i := 0; i < 5; i++ {
+		logger.InfoContext(ctx, "served", "i", i)
+	}
+	if w.String() != "" {
+		t.Fatal("expected nothing written while the writer is blocked")
+	}
+	close(w.gate)
+	if err := h.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(w.String(), "\n"), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected 5 lines, got %q", lines)
+	}
+	for i, line := range lines {
+		want := "INFO [request_id=req-1] served http.service=api http.i=" + string(rune('0'+i))
+		if !strings.HasSuffix(line, want) {
+			t.Fatalf("line %d = %q, want suffix %q", i, line, want)
+		}
+	}
+
+	logger.Info("after close")
+	if !strings.Contains(w.String(), "after close") {
+		t.Fatal("expected records after Close to be written synchronously")
+	}
+}
+
+func TestAsyncHandlerOverflowPolicies(t *testing.T) {
+	t.Run("drop newest", func(t *testing.T) {
+		w := newGatedWriter()
+		h := NewAsyncHandler(&PlainLogHandler{Writer: w, Level: slog.LevelDebug}, WithQueueSize(4), WithOverflowPolicy(OverflowDropNewest))
+		logger := slog.New(h)
+		// the first record may already be with the blocked writer, so up to 5 fit
+		for i := 0; i < 20; i++ {
+			logger.Error("failed")
+		}
+		if h.Dropped() < 15 {
+			t.Fatalf("expected at least 15 dropped, got %d", h.Dropped())
+		}
+		close(w.gate)
+		if err := h.Close(context.Background()); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		out := w.String()
+		if strings.Count(out, "failed") != 20-int(h.Dropped()) {
+			t.Fatalf("unexpected output %q", out)
+		}
+		if !strings.Contains(out, "WARN dropped log records dropped=") {
+			t.Fatalf("expected the drop count to be logged, got %q", out)
+		}
+	})
+
+	t.Run("drop low levels", func(t *testing.T) {
+		w := newGatedWriter()
+		h := NewAsyncHandler(&PlainLogHandler{Writer: w, Level: slog.LevelDebug}, WithQueueSize(8), WithOverflowPolicy(OverflowDropLowLevels))
+		logger := slog.New(h)
+		for i := 0; i < 20; i++ {
+			logger.Info("info")
+		}
+		if h.Dropped() < 13 {
+			t.Fatalf("expected INFO records beyond the low level share to be dropped, got %d", h.Dropped())
+		}
+		dropped := h.Dropped()
+		logger.Warn("warn")
+		logger.Error("error")
+		if h.Dropped() != dropped {
+			t.Fatal("expected WARN and ERROR to use the reserved share of the queue")
+		}
+		close(w.gate)
+		if err := h.Close(context.Background()); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		out := w.String()
+		if !strings.Contains(out, "WARN warn") || !strings.Contains(out, "ERROR error") || !strings.Contains(out, " info=") {
+			t.Fatalf("unexpected output %q", out)
+		}
+	})
+}
+
+func TestAsyncHandlerFlushesOnError(t *testing.T) {
+	var out syncBuffer
+	buffered := NewBufferedWriter(&out, 64<<10)
+	h := NewAsyncHandler(&PlainLogHandler{Writer: buffered, Level: slog.LevelDebug}, WithFlusher(buffered, time.Hour, slog.LevelError))
+	logger := slog.New(h)
+
+	logger.Info("buffered")
+	logger.Error("flushed")
+	waitFor(t, func() bool { return strings.Contains(out.String(), "flushed") })
+	if !strings.Contains(out.String(), "buffered") {
+		t.Fatal("expected the flush to include earlier records")
+	}
+
+	logger.Info("pending")
+	time.Sleep(20 * time.Millisecond)
+	if strings.Contains(out.String(), "pending") {
+		t.Fatal("expected INFO records to stay buffered until the flush interval")
+	}
+	if err := h.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if !strings.Contains(out.String(), "pending") {
+		t.Fatal("expected Close to flush")
+	}
+}
+
+func TestAsyncHandlerCloseHonoursContext(t *testing.T) {
+	w := newGatedWriter()
+	h := NewAsyncHandler(&PlainLogHandler{Writer: w, Level: slog.LevelDebug})
+	logger := slog.New(h)
+	logger.Info("stuck")
+	logger.Info("queued")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := h.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline error, got %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		logger.Info("late")
+	}()
+	close(w.gate)
+	<-done
+	if err := h.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	out := w.String()
+	if !strings.Contains(out, "late") || strings.Index(out, "late") < strings.Index(out, "queued") {
+		t.Fatalf("expected records after Close to follow the drained queue, got %q", out)
+	}
+}
+
+func TestAsyncHandlerDropLowLevelsWithSmallQueue(t *testing.T) {
+	for size := 1; size <= 3; size++ {
+		var out syncBuffer
+		h := NewAsyncHandler(&PlainLogHandler{Writer: &out, Level: slog.LevelDebug}, WithQueueSize(size), WithOverflowPolicy(OverflowDropLowLevels))
+		slog.New(h).Info("hello")
+		if err := h.Close(context.Background()); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		if h.Dropped() != 0 || !strings.Contains(out.String(), "hello") {
+			t.Fatalf("queue size %d: INFO dropped from an empty queue, output %q", size, out.String())
+		}
+	}
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,186 @@
+package logu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// FanoutChild is one destination of a FanoutHandler. Level is checked on top of the
+// handler's own Enabled, so a nil Level leaves it to the handler, and a slog.LevelVar
+// allows changing it at runtime. A nil Filter passes every record.
+type FanoutChild struct {
+	Handler slog.Handler
+	Level   slog.Leveler
+	Filter  FanoutFilter
+}
+
+// FanoutFilter decides whether a record goes to a child.
+type FanoutFilter func(e FanoutEntry) bool
+
+// FanoutEntry is a record together with the logger groups and attributes it was logged
+// with.
+type FanoutEntry struct {
+	Context context.Context
+	Record  slog.Record
+	// Groups are the groups opened with WithGroup, outermost first.
+	Groups []string
+	// Attrs are the attributes added with WithAttrs.
+	Attrs []slog.Attr
+}
+
+// Attr returns the value of the attribute key of the record, the logger or the
+// LogContext of the entry, in that order. Keys are matched without group prefixes.
+func (e FanoutEntry) Attr(key string) (slog.Value, bool) {
+	var value slog.Value
+	found := false
+	e.Record.Attrs(func(a slog.Attr) bool {
+		if a.Key == key {
+			value, found = a.Value.Resolve(), true
+		}
+		return !found
+	})
+	if found {
+		return value, true
+	}
+	for _, a := range slices.Backward(e.Attrs) {
+		if a.Key == key {
+			return a.Value.Resolve(), true
+		}
+	}
+	if lc := GetLogContext(e.Context); lc != nil {
+		for _, item := range slices.Backward(lc.Items) {
+			if item.Name != key {
+				continue
+			}
+			if item.Value == nil {
+				return slog.BoolValue(true), true
+			}
+			return slog.StringValue(*item.Value), true
+		}
+	}
+	return slog.Value{}, false
+}
+
+// GroupFilter passes records of loggers within the group path, e.g. GroupFilter("http")
+// passes records of logger.WithGroup("http") and logger.WithGroup("http").WithGroup("client").
+func GroupFilter(groups ...string) FanoutFilter {
+	return func(e FanoutEntry) bool {
+		return len(e.Groups) >= len(groups) && slices.Equal(e.Groups[:len(groups)], groups)
+	}
+}
+
+// AttrFilter passes records with the attribute key, see FanoutEntry.Attr, formatting to
+// the same string as value. A nil value passes records with the attribute set to
+// anything.
+func AttrFilter(key string, value any) FanoutFilter {
+	want := fmt.Sprint(value)
+	return func(e FanoutEntry) bool {
+		got, ok := e.Attr(key)
+		return ok && (value == nil || got.String() == want)
+	}
+}
+
+// MessagePrefixFilter passes records whose message starts with prefix.
+func MessagePrefixFilter(prefix string) FanoutFilter {
+	return func(e FanoutEntry) bool {
+		return strings.HasPrefix(e.Record.Message, prefix)
+	}
+}
+
+// NotFilter passes the records filter doesn't.
+func NotFilter(filter FanoutFilter) FanoutFilter {
+	return func(e FanoutEntry) bool {
+		return !filter(e)
+	}
+}
+
+// FanoutHandler passes each record to every child that is enabled for its level and whose
+// filter passes it, e.g. plain text on stderr at INFO and JSON to a file at DEBUG:
+//
+//	logger := slog.New(logu.NewFanoutHandler(
+//		logu.FanoutChild{Handler: &logu.PlainLogHandler{Writer: os.Stderr, Level: slog.LevelInfo}},
+//		logu.FanoutChild{Handler: logu.NewStructuredLogHandler(file, slog.LevelDebug, nil)},
+//	))
+//
+// WithAttrs and WithGroup are applied to all children, and the context, with its
+// LogContext, is passed to each of them.
+type FanoutHandler struct {
+	children []FanoutChild
+	attrs    []slog.Attr
+	groups   []string
+}
+
+func NewFanoutHandler(children ...FanoutChild) *FanoutHandler {
+	return &FanoutHandler{children: slices.Clone(children)}
+}
+
+func (h *FanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, child := range h.children {
+		if child.enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle passes the record to the children and returns their joined errors, so one
+// failing destination doesn't stop the others.
+func (h *FanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, child := range h.children {
+		if !child.enabled(ctx, r.Level) {
+			continue
+		}
+		if child.Filter != nil && !child.Filter(FanoutEntry{Context: ctx, Record: r, Groups: h.groups, Attrs: h.attrs}) {
+			continue
+		}
+		// each child gets its own copy, as handlers may add attrs to the record
+		if err := child.Handler.Handle(ctx, r.Clone()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h *FanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	children := make([]FanoutChild, len(h.children))
+	for i, child := range h.children {
+		child.Handler = child.Handler.WithAttrs(attrs)
+		children[i] = child
+	}
+	return &FanoutHandler{
+		children: children,
+		attrs:    append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...),
+		groups:   h.groups,
+	}
+}
+
+func (h *FanoutHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	children := make([]FanoutChild, len(h.children))
+	for i, child := range h.children {
+		child.Handler = child.Handler.WithGroup(name)
+		children[i] = child
+	}
+	return &FanoutHandler{
+		children: children,
+		attrs:    h.attrs,
+		groups:   append(h.groups[:len(h.groups):len(h.groups)], name),
+	}
+}
+
+func (c FanoutChild) enabled(ctx context.Context, level slog.Level) bool {
+	if c.Level != nil && level < c.Level.Level() {
+		return false
+	}
+	return c.Handler.Enabled(ctx, level)
+}
@@ -0,0 +1,109 @@
+package logu
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFanoutHandlerPerChildLevelsAndFormats(t *testing.T) {
+	var plain, structured bytes.Buffer
+	logger := slog.New(NewFanoutHandler(
+		FanoutChild{Handler: &PlainLogHandler{Writer: &plain, Level: slog.LevelInfo}},
+		FanoutChild{Handler: NewStructuredLogHandler(&structured, slog.LevelDebug, []string{"request_id"})},
+	)).With("service", "api").WithGroup("http")
+	ctx := ExtendLogContext(context.Background(), "request_id", "req-1")
+
+	logger.DebugContext(ctx, "parsed", "size", 12)
+	logger.InfoContext(ctx, "served", "status", 200)
+
+	if got := plain.String(); strings.Contains(got, "parsed") || !strings.HasSuffix(got, "INFO [request_id=req-1] served http.service=api http.status=200\n") {
+		t.Fatalf("plain output = %q", got)
+	}
+	lines := strings.Split(strings.TrimSuffix(structured.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 structured lines, got %q", lines)
+	}
+	var got map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &got); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if got["message"] != "served" || got["request_id"] != "req-1" || !strings.Contains(got["attrs"].(string), "http.service = api") {
+		t.Fatalf("structured fields = %#v", got)
+	}
+}
+
+func TestFanoutHandlerEnabled(t *testing.T) {
+	var level slog.LevelVar
+	level.Set(slog.LevelWarn)
+	h := NewFanoutHandler(
+		FanoutChild{Handler: &PlainLogHandler{Writer: &bytes.Buffer{}, Level: slog.LevelDebug}, Level: &level},
+		FanoutChild{Handler: &PlainLogHandler{Writer: &bytes.Buffer{}, Level: slog.LevelError}},
+	)
+	if h.Enabled(context.Background(), slog.LevelInfo) {
+		t.Fatal("expected INFO to be disabled for all children")
+	}
+	level.Set(slog.LevelInfo)
+	if !h.Enabled(context.Background(), slog.LevelInfo) {
+		t.Fatal("expected the LevelVar change to enable INFO")
+	}
+}
+
+func TestFanoutHandlerFilters(t *testing.T) {
+	var httpOut, audit, rest bytes.Buffer
+	logger := slog.New(NewFanoutHandler(
+		FanoutChild{Handler: &PlainLogHandler{Writer: &httpOut}, Filter: GroupFilter("http")},
+		FanoutChild{Handler: &PlainLogHandler{Writer: &audit}, Filter: AttrFilter("audit", true)},
+		FanoutChild{Handler: &PlainLogHandler{Writer: &rest}, Filter: NotFilter(MessagePrefixFilter("cache "))},
+	))
+
+	logger.WithGroup("http").WithGroup("client").Info("request sent")
+	logger.With("audit", true).Info("user deleted")
+	logger.Info("cache miss")
+	logger.Info("login", "audit", false)
+	logger.InfoContext(ExtendLogContext(context.Background(), "audit", true), "role changed")
+
+	checkLines := func(name string, buf *bytes.Buffer, want ...string) {
+		t.Helper()
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		if len(lines) != len(want) {
+			t.Fatalf("%s: expected %d lines, got %q", name, len(want), buf.String())
+		}
+		for i := range want {
+			if !strings.Contains(lines[i], want[i]) {
+				t.Fatalf("%s: line %d = %q, want %q", name, i, lines[i], want[i])
+			}
+		}
+	}
+	checkLines("http", &httpOut, "request sent")
+	checkLines("audit", &audit, "user deleted", "role changed")
+	checkLines("rest", &rest, "request sent", "user deleted", "login", "role changed")
+}
+
+type failingHandler struct {
+	slog.Handler
+}
+
+func (h failingHandler) Handle(context.Context, slog.Record) error {
+	return errors.New("disk full")
+}
+
+func TestFanoutHandlerContinuesAfterChildError(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewFanoutHandler(
+		FanoutChild{Handler: failingHandler{&PlainLogHandler{Writer: &buf}}},
+		FanoutChild{Handler: &PlainLogHandler{Writer: &buf}},
+	)
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "hello", 0)
+	if err := h.Handle(context.Background(), r); err == nil || err.Error() != "disk full" {
+		t.Fatalf("expected the child error, got %v", err)
+	}
+	if !strings.Contains(buf.String(), "hello") {
+		t.Fatal("expected the second child to handle the record")
+	}
+}
@@ -0,0 +1,356 @@
+package logu
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jptrs93/goutil/timeu"
+)
+
+const rotatedTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotatingFile is an io.WriteCloser appending to Path and rotating it once it would grow
+// beyond MaxSize and/or when Schedule comes round, e.g. timeu.DailySchedule{} for
+// midnight UTC. Rotated files are renamed to <name>-<time><ext>, gzipped when Compress is
+// set, and removed once there are more than MaxBackups or they are older than MaxAge.
+// Zero values disable the respective limit.
+//
+// It is safe for concurrent use, so a single RotatingFile can back several handlers:
+//
+//	file := logu.NewRotatingFile(filepath.Join(logDir, "app.log"))
+//	defer file.Close()
+//	logger := slog.New(&logu.PlainLogHandler{Writer: file, Level: slog.LevelInfo})
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	Schedule   timeu.Schedule
+	MaxBackups int
+	MaxAge     time.Duration
+	Compress   bool
+	// OnError is called with errors of background work, reopening on a signal and
+	// compressing or removing backups, which no caller would otherwise see. They are
+	// written to stderr when nil. It must not write to the RotatingFile.
+	OnError func(err error)
+
+	mu           sync.Mutex
+	file         *os.File
+	size         int64
+	nextRotation time.Time
+	closed       bool
+	stopSignals  func()
+	millMu       sync.Mutex
+	millWG       sync.WaitGroup
+	now          func() time.Time
+}
+
+// NewRotatingFile rotates at 100 MiB, keeping 10 compressed backups. The file is opened
+// on the first write.
+func NewRotatingFile(path string) *RotatingFile {
+	return &RotatingFile{
+		Path:       path,
+		MaxSize:    100 << 20,
+		MaxBackups: 10,
+		Compress:   true,
+	}
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.file == nil {
+		if err := f.openLocked(); err != nil {
+			return 0, err
+		}
+	}
+	if f.dueLocked(int64(len(p))) {
+		if err := f.rotateLocked(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file now.
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	if f.file == nil {
+		if err := f.openLocked(); err != nil {
+			return err
+		}
+	}
+	return f.rotateLocked()
+}
+
+// Reopen closes and reopens Path, for when an external tool such as logrotate has moved
+// the file away.
+func (f *RotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	if f.file != nil {
+		err := f.file.Close()
+		f.file = nil
+		if err != nil {
+			return err
+		}
+	}
+	return f.openLocked()
+}
+
+// ReopenOnSignal calls Reopen whenever one of sigs is received, SIGHUP when none are
+// given, until Close.
+func (f *RotatingFile) ReopenOnSignal(sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-c:
+				if err := f.Reopen(); err != nil && !errors.Is(err, os.ErrClosed) {
+					f.reportError(fmt.Errorf("logu: reopening %s: %w", f.Path, err))
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stopSignals != nil {
+		f.stopSignals()
+	}
+	f.stopSignals = func() {
+		signal.Stop(c)
+		close(done)
+	}
+}
+
+// Close closes the file and waits for pending compression and cleanup.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	var err error
+	if !f.closed {
+		f.closed = true
+		if f.stopSignals != nil {
+			f.stopSignals()
+		}
+		if f.file != nil {
+			err = f.file.Close()
+			f.file = nil
+		}
+	}
+	f.mu.Unlock()
+	f.millWG.Wait()
+	return err
+}
+
+func (f *RotatingFile) dueLocked(n int64) bool {
+	if f.MaxSize > 0 && f.size > 0 && f.size+n > f.MaxSize {
+		return true
+	}
+	return f.Schedule != nil && !f.nowTime().Before(f.nextRotation)
+}
+
+func (f *RotatingFile) openLocked() error {
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	if f.Schedule != nil {
+		// an existing file is rotated at the first write after the rotation due since it
+		// was last written
+		since := f.nowTime()
+		if f.size > 0 {
+			since = info.ModTime()
+		}
+		f.nextRotation = f.Schedule.NextAfter(since)
+	}
+	return nil
+}
+
+func (f *RotatingFile) rotateLocked() error {
+	// the file is unusable once Close was called, failed or not, so forget it and let
+	// the next write reopen Path
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return err
+	}
+	now := f.nowTime()
+	if f.size > 0 {
+		if err := os.Rename(f.Path, f.backupName(now)); err != nil {
+			return err
+		}
+	}
+	if err := f.openLocked(); err != nil {
+		return err
+	}
+	if f.Schedule != nil {
+		f.nextRotation = f.Schedule.NextAfter(now)
+	}
+	f.millWG.Add(1)
+	go func() {
+		defer f.millWG.Done()
+		f.mill(now)
+	}()
+	return nil
+}
+
+// backupName returns a free name for a backup rotated at t, moving on by a millisecond
+// on collisions so names keep sorting by rotation time.
+func (f *RotatingFile) backupName(t time.Time) string {
+	prefix, ext := f.backupPrefixExt()
+	for ; ; t = t.Add(time.Millisecond) {
+		name := prefix + t.UTC().Format(rotatedTimeFormat) + ext
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			if _, err := os.Stat(name + ".gz"); os.IsNotExist(err) {
+				return name
+			}
+		}
+	}
+}
+
+func (f *RotatingFile) backupPrefixExt() (string, string) {
+	ext := filepath.Ext(f.Path)
+	return strings.TrimSuffix(f.Path, ext) + "-", ext
+}
+
+type rotatedBackup struct {
+	path string
+	at   time.Time
+}
+
+// mill compresses uncompressed backups and removes those beyond the retention limits.
+// Runs are serialised so two rotations in quick succession don't race on a file.
+func (f *RotatingFile) mill(now time.Time) {
+	f.millMu.Lock()
+	defer f.millMu.Unlock()
+	backups, err := f.backups()
+	if err != nil {
+		f.reportError(fmt.Errorf("logu: listing %s backups: %w", f.Path, err))
+		return
+	}
+	for i, backup := range backups {
+		expired := f.MaxAge > 0 && now.Sub(backup.at) > f.MaxAge
+		if (f.MaxBackups > 0 && i >= f.MaxBackups) || expired {
+			if err := os.Remove(backup.path); err != nil && !os.IsNotExist(err) {
+				f.reportError(fmt.Errorf("logu: removing %s: %w", backup.path, err))
+			}
+			continue
+		}
+		if f.Compress && !strings.HasSuffix(backup.path, ".gz") {
+			if err := gzipFile(backup.path); err != nil {
+				f.reportError(fmt.Errorf("logu: compressing %s: %w", backup.path, err))
+			}
+		}
+	}
+}
+
+// backups returns the rotated files of Path, newest first.
+func (f *RotatingFile) backups() ([]rotatedBackup, error) {
+	prefix, ext := f.backupPrefixExt()
+	entries, err := os.ReadDir(filepath.Dir(f.Path))
+	if err != nil {
+		return nil, err
+	}
+	var backups []rotatedBackup
+	base := filepath.Base(prefix)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, base) || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(name[len(base):], ".gz"), ext)
+		at, err := time.Parse(rotatedTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, rotatedBackup{path: filepath.Join(filepath.Dir(f.Path), name), at: at})
+	}
+	slices.SortFunc(backups, func(a, b rotatedBackup) int {
+		return b.at.Compare(a.at)
+	})
+	return backups, nil
+}
+
+func (f *RotatingFile) reportError(err error) {
+	if f.OnError != nil {
+		f.OnError(err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, err)
+}
+
+func (f *RotatingFile) nowTime() time.Time {
+	if f.now != nil {
+		return f.now()
+	}
+	return time.Now()
+}
+
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
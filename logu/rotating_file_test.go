@@ -0,0 +1,273 @@
+package logu
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jptrs93/goutil/timeu"
+)
+
+func TestRotatingFileRotatesBySizeAndKeepsMaxBackups(t *testing.T) {
+	dir := t.TempDir()
+	f := NewRotatingFile(filepath.Join(dir, "app.log"))
+	f.MaxSize = 100
+	f.MaxBackups = 2
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	f.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	line := strings.Repeat("x", 39) + "\n"
+	for i := 0; i < 10; i++ {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	names := dirNames(t, dir)
+	if len(names) != 3 || names[2] != "app.log" {
+		t.Fatalf("expected two backups and the live file, got %v", names)
+	}
+	for _, name := range names[:2] {
+		if !strings.HasPrefix(name, "app-2026-01-02T03-04-") || !strings.HasSuffix(name, ".log.gz") {
+			t.Fatalf("unexpected backup name %q", name)
+		}
+		if got := gunzipFile(t, filepath.Join(dir, name)); got != line+line {
+			t.Fatalf("unexpected backup content %q", got)
+		}
+	}
+	live, _ := os.ReadFile(filepath.Join(dir, "app.log"))
+	if string(live) != line+line {
+		t.Fatalf("unexpected live content %q", live)
+	}
+}
+
+func TestRotatingFileRotatesOnScheduleAndPrunesByAge(t *testing.T) {
+	dir := t.TempDir()
+	f := NewRotatingFile(filepath.Join(dir, "app.log"))
+	f.MaxSize = 0
+	f.MaxBackups = 0
+	f.MaxAge = 36 * time.Hour
+	f.Compress = false
+	f.Schedule = timeu.DailySchedule{}
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return now }
+
+	for day := 1; day <= 4; day++ {
+		if _, err := fmt.Fprintf(f, "day %d\n", day); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		now = now.Add(24 * time.Hour)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	names := dirNames(t, dir)
+	want := []string{"app-2026-01-03T12-00-00.000.log", "app-2026-01-04T12-00-00.000.log", "app.log"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("expected %v, got %v", want, names)
+	}
+	content, _ := os.ReadFile(filepath.Join(dir, want[1]))
+	if string(content) != "day 3\n" {
+		t.Fatalf("unexpected content %q", content)
+	}
+}
+
+func TestRotatingFileReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	f := NewRotatingFile(path)
+	defer f.Close()
+	logger := slog.New(NewStructuredLogHandler(f, slog.LevelInfo, nil))
+
+	logger.Info("before")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("os.Rename: %v", err)
+	}
+	if err := f.Reopen(); err != nil {
+		t.Fatalf("Reopen: %v", err)
+	}
+	logger.Info("after")
+
+	moved, _ := os.ReadFile(path + ".1")
+	current, _ := os.ReadFile(path)
+	if !strings.Contains(string(moved), "before") || !strings.Contains(string(current), "after") || strings.Contains(string(current), "before") {
+		t.Fatalf("unexpected contents %q / %q", moved, current)
+	}
+}
+
+func TestRotatingFileReopenOnSignal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	f := NewRotatingFile(path)
+	defer f.Close()
+	f.ReopenOnSignal()
+
+	if _, err := io.WriteString(f, "before\n"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("os.Rename: %v", err)
+	}
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatalf("os.FindProcess: %v", err)
+	}
+	if err := process.Signal(syscall.SIGHUP); err != nil {
+		t.Skipf("sending SIGHUP: %v", err)
+	}
+	// the reopened file is created on open
+	waitFor(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	})
+	if _, err := io.WriteString(f, "after\n"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	moved, _ := os.ReadFile(path + ".1")
+	current, _ := os.ReadFile(path)
+	if string(moved) != "before\n" || string(current) != "after\n" {
+		t.Fatalf("unexpected contents %q / %q", moved, current)
+	}
+}
+
+func TestRotatingFileRecoversFromFailedClose(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	f := NewRotatingFile(path)
+	defer f.Close()
+
+	if _, err := io.WriteString(f, "first\n"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	// closing the file behind the RotatingFile's back makes the rotation's Close fail
+	f.file.Close()
+	if err := f.Rotate(); err == nil {
+		t.Fatal("expected Rotate to report the failed Close")
+	}
+	if _, err := io.WriteString(f, "second\n"); err != nil {
+		t.Fatalf("Write after failed rotation: %v", err)
+	}
+	content, _ := os.ReadFile(path)
+	if string(content) != "first\nsecond\n" {
+		t.Fatalf("unexpected content %q", content)
+	}
+}
+
+func TestRotatingFileConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	f := NewRotatingFile(filepath.Join(dir, "app.log"))
+	f.MaxSize = 1 << 10
+	f.MaxBackups = 0
+	f.Compress = false
+	logger := slog.New(&PlainLogHandler{Writer: f, Level: slog.LevelInfo})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				logger.Info("message", "goroutine", g, "i", i)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	lines := 0
+	for _, name := range dirNames(t, dir) {
+		content, _ := os.ReadFile(filepath.Join(dir, name))
+		if len(content) > 1<<10 {
+			t.Fatalf("%s exceeds MaxSize with %d bytes", name, len(content))
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+			if !strings.Contains(line, " INFO message goroutine=") {
+				t.Fatalf("interleaved line %q", line)
+			}
+			lines++
+		}
+	}
+	if lines != 400 {
+		t.Fatalf("expected 400 lines, got %d", lines)
+	}
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("os.ReadDir: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func gunzipFile(t *testing.T, path string) string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("os.Open: %v", err)
+	}
+	defer file.Close()
+	r, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("io.ReadAll: %v", err)
+	}
+	return string(content)
+}
+
+func TestRotatingFileReportsBackgroundErrors(t *testing.T) {
+	dir := t.TempDir()
+	f := NewRotatingFile(filepath.Join(dir, "app.log"))
+	var mu sync.Mutex
+	var errs []error
+	f.OnError = func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+	backup := filepath.Join(dir, "app-2026-01-02T03-04-05.000.log")
+	if err := os.WriteFile(backup, []byte("line\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	// a directory in place of the temporary file makes compression fail
+	if err := os.Mkdir(backup+".gz.tmp", 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+
+	f.mill(time.Now())
+	mu.Lock()
+	defer mu.Unlock()
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "compressing") {
+		t.Fatalf("errors = %v", errs)
+	}
+	if _, err := os.Stat(backup); err != nil {
+		t.Fatalf("backup lost after failed compression: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package tlsu
+
+import (
+	"crypto/tls"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/jptrs93/goutil/pathu"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	LetsEncryptURL        = acme.LetsEncryptURL
+	LetsEncryptStagingURL = "https://acme-staging-v02.api.letsencrypt.org/directory"
+)
+
+// ACMEManager obtains certificates over ACME (RFC 8555), Let's Encrypt by default, on
+// the first handshake for each host and renews them RenewBefore their expiry. It proves
+// control of a host with the tls-alpn-01 challenge, served by the TLSConfig handshake
+// hook, or with http-01 once HTTPHandler is mounted on port 80.
+//
+// Creating a manager accepts the CA's terms of service.
+type ACMEManager struct {
+	autocert.Manager
+}
+
+// NewACMEManager keeps the account key and certificates in the "acme" directory under
+// pathu.ResolveAppDataDir(appName). Only hosts get certificates.
+func NewACMEManager(appName string, email string, hosts ...string) (*ACMEManager, error) {
+	dataDir, err := pathu.ResolveAppDataDir(appName, false)
+	if err != nil {
+		return nil, err
+	}
+	return NewACMEManagerInDir(filepath.Join(dataDir, "acme"), email, hosts...), nil
+}
+
+func NewACMEManagerInDir(dir string, email string, hosts ...string) *ACMEManager {
+	return &ACMEManager{Manager: autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(dir),
+		HostPolicy:  autocert.HostWhitelist(hosts...),
+		Email:       email,
+		RenewBefore: 30 * 24 * time.Hour,
+	}}
+}
+
+// UseDirectory points the manager at another ACME CA, e.g. LetsEncryptStagingURL while
+// testing a deployment. A nil httpClient uses http.DefaultClient. It must be called
+// before the first certificate is requested, and with the same directory for a given
+// storage dir, as certificates are cached without regard to their CA.
+func (m *ACMEManager) UseDirectory(directoryURL string, httpClient *http.Client) {
+	m.Client = &acme.Client{DirectoryURL: directoryURL, HTTPClient: httpClient}
+}
+
+// TLSConfig returns a DefaultServerConfig serving ACME certificates and answering
+// tls-alpn-01 challenges.
+func (m *ACMEManager) TLSConfig() *tls.Config {
+	config := DefaultServerConfig()
+	config.GetCertificate = m.GetCertificate
+	config.NextProtos = append(slices.Clone(config.NextProtos), acme.ALPNProto)
+	return config
+}
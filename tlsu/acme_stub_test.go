@@ -0,0 +1,513 @@
+package tlsu
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// acmeStub is a minimal in-process RFC 8555 CA. It checks JWS signatures and nonces,
+// validates challenges synchronously by connecting to httpAddr (http-01) or tlsAddr
+// (tls-alpn-01) instead of resolving the identifier, and issues certs from a DevCA.
+type acmeStub struct {
+	server     *httptest.Server
+	ca         *DevCA
+	challenges []string
+	httpAddr   string
+	tlsAddr    string
+
+	mu       sync.Mutex
+	seq      int
+	nonces   map[string]bool
+	accounts map[string]crypto.PublicKey
+	orders   map[string]*acmeStubOrder
+	authzs   map[string]*acmeStubAuthz
+	certs    map[string][]byte
+	issued   int
+}
+
+type acmeStubOrder struct {
+	Status         string   `json:"status"`
+	Identifiers    []acmeID `json:"identifiers"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate,omitempty"`
+}
+
+type acmeStubAuthz struct {
+	Status     string               `json:"status"`
+	Identifier acmeID               `json:"identifier"`
+	Challenges []*acmeStubChallenge `json:"challenges"`
+	order      *acmeStubOrder
+}
+
+type acmeStubChallenge struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+	authz  *acmeStubAuthz
+}
+
+type acmeID struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+func newACMEStub(t *testing.T, challenges ...string) *acmeStub {
+	t.Helper()
+	ca, err := LoadOrCreateDevCAInDir(t.TempDir(), "acme stub CA")
+	if err != nil {
+		t.Fatalf("LoadOrCreateDevCAInDir: %v", err)
+	}
+	s := &acmeStub{
+		ca:         ca,
+		challenges: challenges,
+		nonces:     map[string]bool{},
+		accounts:   map[string]crypto.PublicKey{},
+		orders:     map[string]*acmeStubOrder{},
+		authzs:     map[string]*acmeStubAuthz{},
+		certs:      map[string][]byte{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /directory", s.directory)
+	mux.HandleFunc("/new-nonce", func(w http.ResponseWriter, r *http.Request) { s.nonce(w) })
+	mux.HandleFunc("POST /new-account", s.newAccount)
+	mux.HandleFunc("POST /new-order", s.newOrder)
+	mux.HandleFunc("POST /order/{id}", s.getOrder)
+	mux.HandleFunc("POST /authz/{id}", s.getAuthz)
+	mux.HandleFunc("POST /challenge/{id}", s.acceptChallenge)
+	mux.HandleFunc("POST /finalize/{id}", s.finalize)
+	mux.HandleFunc("POST /cert/{id}", s.getCert)
+	s.server = httptest.NewTLSServer(mux)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *acmeStub) directoryURL() string {
+	return s.server.URL + "/directory"
+}
+
+func (s *acmeStub) issuedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issued
+}
+
+func (s *acmeStub) directory(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, map[string]any{
+		"newNonce":   s.server.URL + "/new-nonce",
+		"newAccount": s.server.URL + "/new-account",
+		"newOrder":   s.server.URL + "/new-order",
+		"meta":       map[string]any{"termsOfService": s.server.URL + "/terms"},
+	})
+}
+
+func (s *acmeStub) nonce(w http.ResponseWriter) {
+	s.mu.Lock()
+	s.seq++
+	nonce := fmt.Sprintf("nonce-%d", s.seq)
+	s.nonces[nonce] = true
+	s.mu.Unlock()
+	w.Header().Set("Replay-Nonce", nonce)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func (s *acmeStub) newAccount(w http.ResponseWriter, r *http.Request) {
+	payload, account, err := s.verifyJWS(r)
+	if err != nil {
+		s.problem(w, "malformed", err)
+		return
+	}
+	var req struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil || !req.TermsOfServiceAgreed {
+		s.problem(w, "userActionRequired", errors.New("terms of service not agreed"))
+		return
+	}
+	w.Header().Set("Location", s.server.URL+"/account/"+account)
+	s.writeJSON(w, http.StatusCreated, map[string]any{"status": "valid", "contact": req.Contact})
+}
+
+func (s *acmeStub) newOrder(w http.ResponseWriter, r *http.Request) {
+	payload, _, err := s.verifyJWS(r)
+	if err != nil {
+		s.problem(w, "malformed", err)
+		return
+	}
+	var req struct {
+		Identifiers []acmeID `json:"identifiers"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil || len(req.Identifiers) == 0 {
+		s.problem(w, "malformed", errors.New("no identifiers"))
+		return
+	}
+	s.mu.Lock()
+	id := s.nextID()
+	order := &acmeStubOrder{
+		Status:      "pending",
+		Identifiers: req.Identifiers,
+		Finalize:    s.server.URL + "/finalize/" + id,
+	}
+	for _, identifier := range req.Identifiers {
+		authzID := s.nextID()
+		authz := &acmeStubAuthz{Status: "pending", Identifier: identifier, order: order}
+		for _, typ := range s.challenges {
+			challengeID := s.nextID()
+			challenge := &acmeStubChallenge{Type: typ, URL: s.server.URL + "/challenge/" + challengeID, Token: "token-" + challengeID, Status: "pending", authz: authz}
+			authz.Challenges = append(authz.Challenges, challenge)
+		}
+		s.authzs[authzID] = authz
+		order.Authorizations = append(order.Authorizations, s.server.URL+"/authz/"+authzID)
+	}
+	s.orders[id] = order
+	body, _ := json.Marshal(order)
+	s.mu.Unlock()
+	w.Header().Set("Location", s.server.URL+"/order/"+id)
+	s.writeRaw(w, http.StatusCreated, body)
+}
+
+func (s *acmeStub) getOrder(w http.ResponseWriter, r *http.Request) {
+	if _, _, err := s.verifyJWS(r); err != nil {
+		s.problem(w, "malformed", err)
+		return
+	}
+	s.writeOrder(w, r.PathValue("id"))
+}
+
+func (s *acmeStub) getAuthz(w http.ResponseWriter, r *http.Request) {
+	payload, _, err := s.verifyJWS(r)
+	if err != nil {
+		s.problem(w, "malformed", err)
+		return
+	}
+	s.mu.Lock()
+	authz, ok := s.authzs[r.PathValue("id")]
+	var body []byte
+	if ok {
+		var update struct {
+			Status string `json:"status"`
+		}
+		if json.Unmarshal(payload, &update) == nil && update.Status == "deactivated" {
+			authz.Status = "deactivated"
+		}
+		body, _ = json.Marshal(authz)
+	}
+	s.mu.Unlock()
+	if !ok {
+		s.problem(w, "malformed", errors.New("no such authorization"))
+		return
+	}
+	s.writeRaw(w, http.StatusOK, body)
+}
+
+func (s *acmeStub) acceptChallenge(w http.ResponseWriter, r *http.Request) {
+	_, account, err := s.verifyJWS(r)
+	if err != nil {
+		s.problem(w, "malformed", err)
+		return
+	}
+	s.mu.Lock()
+	var challenge *acmeStubChallenge
+	for _, authz := range s.authzs {
+		for _, c := range authz.Challenges {
+			if c.URL == s.server.URL+"/challenge/"+r.PathValue("id") {
+				challenge = c
+			}
+		}
+	}
+	accountKey := s.accounts[account]
+	s.mu.Unlock()
+	if challenge == nil {
+		s.problem(w, "malformed", errors.New("no such challenge"))
+		return
+	}
+	thumbprint, err := acme.JWKThumbprint(accountKey)
+	if err != nil {
+		s.problem(w, "serverInternal", err)
+		return
+	}
+	keyAuth := challenge.Token + "." + thumbprint
+	validateErr := s.validate(challenge.Type, challenge.authz.Identifier.Value, challenge.Token, keyAuth)
+
+	s.mu.Lock()
+	if validateErr != nil {
+		challenge.Status, challenge.authz.Status, challenge.authz.order.Status = "invalid", "invalid", "invalid"
+	} else {
+		challenge.Status, challenge.authz.Status = "valid", "valid"
+		s.updateOrderLocked(challenge.authz.order)
+	}
+	body, _ := json.Marshal(challenge)
+	s.mu.Unlock()
+	s.writeRaw(w, http.StatusOK, body)
+}
+
+func (s *acmeStub) validate(typ, domain, token, keyAuth string) error {
+	switch typ {
+	case "http-01":
+		req, _ := http.NewRequest("GET", "http://"+s.httpAddr+"/.well-known/acme-challenge/"+token, nil)
+		req.Host = domain
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if strings.TrimSpace(string(body)) != keyAuth {
+			return fmt.Errorf("unexpected http-01 response %q", body)
+		}
+		return nil
+	case "tls-alpn-01":
+		conn, err := tls.Dial("tcp", s.tlsAddr, &tls.Config{
+			ServerName:         domain,
+			NextProtos:         []string{acme.ALPNProto},
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		state := conn.ConnectionState()
+		if state.NegotiatedProtocol != acme.ALPNProto || len(state.PeerCertificates) == 0 {
+			return errors.New("acme-tls/1 not negotiated")
+		}
+		cert := state.PeerCertificates[0]
+		if !slices.Contains(cert.DNSNames, domain) {
+			return errors.New("challenge cert not for domain")
+		}
+		sum := sha256.Sum256([]byte(keyAuth))
+		want, _ := asn1.Marshal(sum[:])
+		for _, ext := range cert.Extensions {
+			if ext.Id.Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}) && string(ext.Value) == string(want) {
+				return nil
+			}
+		}
+		return errors.New("challenge cert lacks the acmeIdentifier")
+	}
+	return fmt.Errorf("unsupported challenge %q", typ)
+}
+
+func (s *acmeStub) finalize(w http.ResponseWriter, r *http.Request) {
+	payload, _, err := s.verifyJWS(r)
+	if err != nil {
+		s.problem(w, "malformed", err)
+		return
+	}
+	var req struct {
+		CSR string `json:"csr"`
+	}
+	_ = json.Unmarshal(payload, &req)
+	der, err := base64.RawURLEncoding.DecodeString(req.CSR)
+	if err != nil {
+		s.problem(w, "badCSR", err)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil || csr.CheckSignature() != nil {
+		s.problem(w, "badCSR", errors.New("invalid CSR"))
+		return
+	}
+
+	id := r.PathValue("id")
+	s.mu.Lock()
+	order, ok := s.orders[id]
+	ready := ok && order.Status == "ready"
+	var names []string
+	if ok {
+		for _, identifier := range order.Identifiers {
+			names = append(names, identifier.Value)
+		}
+	}
+	s.mu.Unlock()
+	if !ready {
+		s.problem(w, "orderNotReady", errors.New("order not ready"))
+		return
+	}
+	if !slices.Equal(slices.Sorted(slices.Values(csr.DNSNames)), slices.Sorted(slices.Values(names))) {
+		s.problem(w, "badCSR", errors.New("CSR names differ from the order"))
+		return
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, s.ca.Cert, csr.PublicKey, s.ca.Key)
+	if err != nil {
+		s.problem(w, "serverInternal", err)
+		return
+	}
+	chain := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), s.ca.CertPEM()...)
+
+	s.mu.Lock()
+	s.certs[id] = chain
+	s.issued++
+	order.Status = "valid"
+	order.Certificate = s.server.URL + "/cert/" + id
+	s.mu.Unlock()
+	s.writeOrder(w, id)
+}
+
+func (s *acmeStub) getCert(w http.ResponseWriter, r *http.Request) {
+	if _, _, err := s.verifyJWS(r); err != nil {
+		s.problem(w, "malformed", err)
+		return
+	}
+	s.mu.Lock()
+	chain, ok := s.certs[r.PathValue("id")]
+	s.mu.Unlock()
+	if !ok {
+		s.problem(w, "malformed", errors.New("no such certificate"))
+		return
+	}
+	s.nonce(w)
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	_, _ = w.Write(chain)
+}
+
+func (s *acmeStub) writeOrder(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	order, ok := s.orders[id]
+	body, _ := json.Marshal(order)
+	s.mu.Unlock()
+	if !ok {
+		s.problem(w, "malformed", errors.New("no such order"))
+		return
+	}
+	w.Header().Set("Location", s.server.URL+"/order/"+id)
+	s.writeRaw(w, http.StatusOK, body)
+}
+
+func (s *acmeStub) updateOrderLocked(order *acmeStubOrder) {
+	for _, authzURL := range order.Authorizations {
+		if s.authzs[authzURL[strings.LastIndex(authzURL, "/")+1:]].Status != "valid" {
+			return
+		}
+	}
+	order.Status = "ready"
+}
+
+// verifyJWS checks the signature, nonce and url of a flattened JWS request, returning
+// its payload and the id of the account it was signed by. Requests with an embedded jwk
+// register that key, with its thumbprint as account id.
+func (s *acmeStub) verifyJWS(r *http.Request) ([]byte, string, error) {
+	var body struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, "", err
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(body.Protected)
+	if err != nil {
+		return nil, "", err
+	}
+	var header struct {
+		Alg   string `json:"alg"`
+		Nonce string `json:"nonce"`
+		URL   string `json:"url"`
+		KID   string `json:"kid"`
+		JWK   *struct {
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"jwk"`
+	}
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return nil, "", err
+	}
+	if header.Alg != "ES256" {
+		return nil, "", fmt.Errorf("unsupported alg %q", header.Alg)
+	}
+	if header.URL != s.server.URL+r.URL.Path {
+		return nil, "", fmt.Errorf("url %q does not match the request", header.URL)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.nonces[header.Nonce] {
+		return nil, "", errors.New("bad nonce")
+	}
+	delete(s.nonces, header.Nonce)
+	var key *ecdsa.PublicKey
+	var account string
+	if header.JWK != nil {
+		x, _ := base64.RawURLEncoding.DecodeString(header.JWK.X)
+		y, _ := base64.RawURLEncoding.DecodeString(header.JWK.Y)
+		key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		thumbprint, err := acme.JWKThumbprint(key)
+		if err != nil {
+			return nil, "", err
+		}
+		account = thumbprint
+		s.accounts[account] = key
+	} else {
+		account = strings.TrimPrefix(header.KID, s.server.URL+"/account/")
+		pub, ok := s.accounts[account]
+		if !ok {
+			return nil, "", errors.New("unknown account")
+		}
+		key = pub.(*ecdsa.PublicKey)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(body.Signature)
+	if err != nil || len(sig) != 64 {
+		return nil, "", errors.New("bad signature encoding")
+	}
+	digest := sha256.Sum256([]byte(body.Protected + "." + body.Payload))
+	if !ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, "", errors.New("bad signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(body.Payload)
+	return payload, account, err
+}
+
+func (s *acmeStub) nextID() string {
+	s.seq++
+	return fmt.Sprint(s.seq)
+}
+
+func (s *acmeStub) writeJSON(w http.ResponseWriter, status int, v any) {
+	body, _ := json.Marshal(v)
+	s.writeRaw(w, status, body)
+}
+
+func (s *acmeStub) writeRaw(w http.ResponseWriter, status int, body []byte) {
+	s.nonce(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+func (s *acmeStub) problem(w http.ResponseWriter, typ string, err error) {
+	s.nonce(w)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"type": "urn:ietf:params:acme:error:" + typ, "detail": err.Error()})
+}
@@ -0,0 +1,111 @@
+package tlsu
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestACMEManagerTLSALPN01(t *testing.T) {
+	stub := newACMEStub(t, "tls-alpn-01")
+	dir := t.TempDir()
+	manager := NewACMEManagerInDir(dir, "ops@example.test", "example.test")
+	manager.UseDirectory(stub.directoryURL(), stub.server.Client())
+	addr := serveACMETLS(t, manager)
+	stub.tlsAddr = addr
+
+	if body := getACMETestPage(t, stub, addr, "example.test"); body != "ok" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	if stub.issuedCount() != 1 {
+		t.Fatalf("expected one issued certificate, got %d", stub.issuedCount())
+	}
+	for _, name := range []string{"acme_account+key", "example.test"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("expected %s in the storage dir: %v", name, err)
+		}
+	}
+
+	// a new manager on the same storage serves the stored certificate
+	restarted := NewACMEManagerInDir(dir, "ops@example.test", "example.test")
+	restarted.UseDirectory(stub.directoryURL(), stub.server.Client())
+	if body := getACMETestPage(t, stub, serveACMETLS(t, restarted), "example.test"); body != "ok" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	if stub.issuedCount() != 1 {
+		t.Fatalf("expected the stored certificate to be reused, got %d issued", stub.issuedCount())
+	}
+
+	// hosts outside the whitelist never reach the CA
+	client := acmeTestClient(stub, addr)
+	if _, err := client.Get("https://other.test/"); err == nil {
+		t.Fatal("expected a handshake failure for a host not managed")
+	}
+	if stub.issuedCount() != 1 {
+		t.Fatalf("expected no certificate for other.test, got %d issued", stub.issuedCount())
+	}
+}
+
+func TestACMEManagerHTTP01(t *testing.T) {
+	stub := newACMEStub(t, "http-01")
+	manager := NewACMEManagerInDir(t.TempDir(), "", "example.test")
+	manager.UseDirectory(stub.directoryURL(), stub.server.Client())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	httpServer := &http.Server{Handler: manager.HTTPHandler(nil)}
+	go httpServer.Serve(listener)
+	t.Cleanup(func() { httpServer.Close() })
+	stub.httpAddr = listener.Addr().String()
+
+	addr := serveACMETLS(t, manager)
+	if body := getACMETestPage(t, stub, addr, "example.test"); body != "ok" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	if stub.issuedCount() != 1 {
+		t.Fatalf("expected one issued certificate, got %d", stub.issuedCount())
+	}
+}
+
+func serveACMETLS(t *testing.T, manager *ACMEManager) string {
+	t.Helper()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", manager.TLSConfig())
+	if err != nil {
+		t.Fatalf("tls.Listen: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}), ErrorLog: log.New(io.Discard, "", 0)}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return listener.Addr().String()
+}
+
+// acmeTestClient trusts the stub CA and connects to addr whatever the URL host.
+func acmeTestClient(stub *acmeStub, addr string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: stub.ca.CertPool()},
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+}
+
+func getACMETestPage(t *testing.T, stub *acmeStub, addr string, host string) string {
+	t.Helper()
+	resp, err := acmeTestClient(stub, addr).Get("https://" + host + "/")
+	if err != nil {
+		t.Fatalf("request to %s: %v", host, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
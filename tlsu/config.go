@@ -0,0 +1,159 @@
+package tlsu
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultServerConfig returns a server config with TLS 1.2 as the minimum, only forward
+// secret AEAD suites for TLS 1.2 and h2 advertised. Set Certificates or GetCertificate
+// on the result.
+func DefaultServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519MLKEM768, tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+}
+
+// NewServerTLSConfig returns a DefaultServerConfig serving the cert and key files named
+// by TLS_CERT_FILE and TLS_PRIVATE_KEY_FILE, reloaded when they change on disk. Without
+// them it serves an in-memory self-signed cert for hosts, or for localhost when none are
+// given.
+func NewServerTLSConfig(hosts ...string) (*tls.Config, error) {
+	config := DefaultServerConfig()
+	keyFile := os.Getenv(TlsPrivateKeyFileEnvVar)
+	certFile := os.Getenv(TlsCertFileEnvVar)
+	if keyFile != "" && certFile != "" {
+		reloader, err := NewCertReloader(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.GetCertificate = reloader.GetCertificate
+		return config, nil
+	}
+	if len(hosts) == 0 {
+		hosts = defaultHosts
+	}
+	cert, err := SelfSignedCertificate(hosts...)
+	if err != nil {
+		return nil, err
+	}
+	config.Certificates = []tls.Certificate{*cert}
+	return config, nil
+}
+
+func MustNewServerTLSConfig(hosts ...string) *tls.Config {
+	config, err := NewServerTLSConfig(hosts...)
+	if err != nil {
+		panic(err)
+	}
+	return config
+}
+
+// CertReloader serves a cert/key pair from disk, re-reading it when either file's
+// modification time or size changes, e.g. after a cert-manager renewal. The files are
+// checked at most once per CheckInterval. A pair that fails to load, such as while only
+// one of the files has been replaced, keeps the previous certificate in use and is
+// logged as an error.
+type CertReloader struct {
+	CertFile      string
+	KeyFile       string
+	CheckInterval time.Duration
+	// Logger reports failed reloads, slog.Default() when nil.
+	Logger *slog.Logger
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	certStamp fileStamp
+	keyStamp  fileStamp
+	checkedAt time.Time
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewCertReloader loads the pair, failing when it can't be used.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		CertFile:      certFile,
+		KeyFile:       keyFile,
+		CheckInterval: 10 * time.Second,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload unconditionally re-reads the pair.
+func (r *CertReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reloadLocked()
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now := time.Now(); now.Sub(r.checkedAt) >= r.CheckInterval {
+		r.checkedAt = now
+		certStamp, certErr := statFile(r.CertFile)
+		keyStamp, keyErr := statFile(r.KeyFile)
+		if certErr == nil && keyErr == nil && (certStamp != r.certStamp || keyStamp != r.keyStamp) {
+			// on failure the old pair is kept, and the next check tries again
+			if err := r.reloadLocked(); err != nil {
+				r.logger().Error("reloading TLS certificate failed, keeping the previous one", "cert_file", r.CertFile, "key_file", r.KeyFile, "error", err)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+func (r *CertReloader) logger() *slog.Logger {
+	if r.Logger != nil {
+		return r.Logger
+	}
+	return slog.Default()
+}
+
+func (r *CertReloader) reloadLocked() error {
+	certStamp, err := statFile(r.CertFile)
+	if err != nil {
+		return err
+	}
+	keyStamp, err := statFile(r.KeyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS key pair: %w", err)
+	}
+	r.cert = &cert
+	r.certStamp, r.keyStamp = certStamp, keyStamp
+	r.checkedAt = time.Now()
+	return nil
+}
+
+func statFile(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
@@ -0,0 +1,99 @@
+package tlsu
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewServerTLSConfigInMemory(t *testing.T) {
+	t.Setenv(TlsCertFileEnvVar, "")
+	t.Setenv(TlsPrivateKeyFileEnvVar, "")
+	config, err := NewServerTLSConfig()
+	if err != nil {
+		t.Fatalf("NewServerTLSConfig: %v", err)
+	}
+	if config.MinVersion != tls.VersionTLS12 || len(config.Certificates) != 1 {
+		t.Fatalf("unexpected config %+v", config)
+	}
+	if err := config.Certificates[0].Leaf.VerifyHostname("127.0.0.1"); err != nil {
+		t.Fatalf("VerifyHostname: %v", err)
+	}
+}
+
+func TestCertReloaderPicksUpRenewedFiles(t *testing.T) {
+	ca, err := LoadOrCreateDevCAInDir(t.TempDir(), "test development CA")
+	if err != nil {
+		t.Fatalf("LoadOrCreateDevCAInDir: %v", err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeTestPair(t, ca, certFile, keyFile, time.Hour)
+	t.Setenv(TlsCertFileEnvVar, certFile)
+	t.Setenv(TlsPrivateKeyFileEnvVar, keyFile)
+
+	config, err := NewServerTLSConfig()
+	if err != nil {
+		t.Fatalf("NewServerTLSConfig: %v", err)
+	}
+	first, err := config.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate: %v", err)
+	}
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertReloader: %v", err)
+	}
+	reloader.CheckInterval = 0
+	var logs bytes.Buffer
+	reloader.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+	if cert, _ := reloader.GetCertificate(nil); cert.Leaf.SerialNumber.Cmp(first.Leaf.SerialNumber) != 0 {
+		t.Fatal("expected the pair on disk")
+	}
+
+	// a half written renewal keeps the old pair in use
+	if err := os.WriteFile(certFile, []byte("garbage"), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	if cert, _ := reloader.GetCertificate(nil); cert.Leaf.SerialNumber.Cmp(first.Leaf.SerialNumber) != 0 {
+		t.Fatal("expected the previous pair while the files are invalid")
+	}
+	if !strings.Contains(logs.String(), "reloading TLS certificate failed") {
+		t.Fatalf("expected the failed reload to be logged, got %q", logs.String())
+	}
+
+	writeTestPair(t, ca, certFile, keyFile, time.Hour)
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(certFile, future, future)
+	cert, _ := reloader.GetCertificate(nil)
+	if cert.Leaf.SerialNumber.Cmp(first.Leaf.SerialNumber) == 0 {
+		t.Fatal("expected the renewed pair to be served")
+	}
+}
+
+func writeTestPair(t *testing.T, ca *DevCA, certFile, keyFile string, ttl time.Duration) {
+	t.Helper()
+	leaf, err := issueCert(ca.Cert, ca.Key, &x509.Certificate{DNSNames: []string{"localhost"}}, ttl)
+	if err != nil {
+		t.Fatalf("issueCert: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(leaf.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatalf("x509.MarshalECPrivateKey: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Certificate[0]}), 0644); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+}
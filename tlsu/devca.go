@@ -0,0 +1,424 @@
+package tlsu
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jptrs93/goutil/fileu"
+	"github.com/jptrs93/goutil/pathu"
+)
+
+const (
+	devCACertFile = "ca.pem"
+	devCAKeyFile  = "ca-key.pem"
+	devCALockFile = "ca.lock"
+	devCATTL      = 10 * 365 * 24 * time.Hour
+	// devCAStaleLockAge is when a lock left behind by a crashed process is broken,
+	// creating a CA takes milliseconds.
+	devCAStaleLockAge = 30 * time.Second
+)
+
+// DevCA is a local certificate authority for development. Its root is created once and
+// kept on disk, so after trusting CertPEM a single time (e.g. via the OS keychain or
+// curl --cacert) every leaf it issues is trusted by browsers and tools.
+type DevCA struct {
+	Dir  string
+	Cert *x509.Certificate
+	Key  *ecdsa.PrivateKey
+	// LeafTTL is the validity of issued leaves. It is kept below the 398 days browsers
+	// accept for leaf certificates.
+	LeafTTL time.Duration
+	// RenewBefore is how long before expiry a cached or stored leaf is re-issued.
+	RenewBefore time.Duration
+
+	certPEM []byte
+	mu      sync.Mutex
+	leaves  map[string]*tls.Certificate
+}
+
+// LoadOrCreateDevCA opens the CA kept in the "devca" directory under
+// pathu.ResolveAppDataDir(appName), creating it on first use.
+func LoadOrCreateDevCA(appName string) (*DevCA, error) {
+	dataDir, err := pathu.ResolveAppDataDir(appName, false)
+	if err != nil {
+		return nil, err
+	}
+	return LoadOrCreateDevCAInDir(filepath.Join(dataDir, "devca"), appName+" development CA")
+}
+
+// LoadOrCreateDevCAInDir opens the CA kept in dir, creating a new root named name when
+// dir holds none. Creation holds a lock file in dir, so processes starting together
+// agree on a single root.
+func LoadOrCreateDevCAInDir(dir string, name string) (*DevCA, error) {
+	if err := fileu.EnsureDirWithPerm(dir, 0700); err != nil {
+		return nil, err
+	}
+	certPath := filepath.Join(dir, devCACertFile)
+	keyPath := filepath.Join(dir, devCAKeyFile)
+	certPEM, err := os.ReadFile(certPath)
+	if errors.Is(err, os.ErrNotExist) {
+		certPEM, err = createDevCAOnce(dir, certPath, keyPath, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading dev CA cert: %w", err)
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading dev CA key: %w", err)
+	}
+	cert, key, err := parseCertAndKey(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("loading dev CA: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("loading dev CA: %s is not a CA certificate", certPath)
+	}
+	return &DevCA{
+		Dir:         dir,
+		Cert:        cert,
+		Key:         key,
+		LeafTTL:     90 * 24 * time.Hour,
+		RenewBefore: 30 * 24 * time.Hour,
+		certPEM:     certPEM,
+		leaves:      map[string]*tls.Certificate{},
+	}, nil
+}
+
+// CertPEM returns the PEM encoded root certificate to add to trust stores.
+func (ca *DevCA) CertPEM() []byte {
+	return slices.Clone(ca.certPEM)
+}
+
+// ExportCertPEM writes the root certificate to path, e.g. for
+// `security add-trusted-cert` or /usr/local/share/ca-certificates.
+func (ca *DevCA) ExportCertPEM(path string) error {
+	return os.WriteFile(path, ca.certPEM, 0644)
+}
+
+// CertPool returns a pool containing only the root, for clients in tests and tooling.
+func (ca *DevCA) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	return pool
+}
+
+// Leaf returns a server certificate for hosts (DNS names and IP addresses), issuing a
+// new one when none is cached or the cached one is within RenewBefore of expiring.
+func (ca *DevCA) Leaf(hosts ...string) (*tls.Certificate, error) {
+	if len(hosts) == 0 {
+		return nil, errors.New("no hosts given")
+	}
+	key := leafKey(hosts)
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if leaf, ok := ca.leaves[key]; ok && !ca.needsRenewal(leaf.Leaf) {
+		return leaf, nil
+	}
+	leaf, err := ca.issueLeaf(hosts)
+	if err != nil {
+		return nil, err
+	}
+	ca.leaves[key] = leaf
+	return leaf, nil
+}
+
+// GetCertificate returns a tls.Config.GetCertificate hook serving a leaf for hosts. The
+// leaf is looked up per handshake, so long running servers pick up re-issued leaves
+// without restarting.
+func (ca *DevCA) GetCertificate(hosts ...string) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	hosts = slices.Clone(hosts)
+	return func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return ca.Leaf(hosts...)
+	}
+}
+
+// LeafFiles returns the paths of a PEM cert (leaf followed by the root) and key for hosts
+// stored in the CA directory, for servers that take file paths. Stored files are reused
+// across restarts and rewritten once within RenewBefore of expiring.
+func (ca *DevCA) LeafFiles(hosts ...string) (certFile, keyFile string, err error) {
+	if len(hosts) == 0 {
+		return "", "", errors.New("no hosts given")
+	}
+	name := sha256.Sum256([]byte(leafKey(hosts)))
+	base := filepath.Join(ca.Dir, "leaf-"+hex.EncodeToString(name[:8]))
+	certFile, keyFile = base+".pem", base+"-key.pem"
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if certPEM, err := os.ReadFile(certFile); err == nil {
+		if keyPEM, err := os.ReadFile(keyFile); err == nil {
+			if cert, _, err := parseCertAndKey(certPEM, keyPEM); err == nil && !ca.needsRenewal(cert) {
+				return certFile, keyFile, nil
+			}
+		}
+	}
+	leaf, err := ca.issueLeaf(hosts)
+	if err != nil {
+		return "", "", err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(leaf.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	var chainPEM []byte
+	for _, der := range leaf.Certificate {
+		chainPEM = append(chainPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	if err := writeFileAtomic(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return "", "", err
+	}
+	if err := writeFileAtomic(certFile, chainPEM, 0644); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
+func (ca *DevCA) needsRenewal(cert *x509.Certificate) bool {
+	return cert == nil || time.Now().After(cert.NotAfter.Add(-ca.RenewBefore))
+}
+
+func (ca *DevCA) issueLeaf(hosts []string) (*tls.Certificate, error) {
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			Organization: ca.Cert.Subject.Organization,
+			CommonName:   hosts[0],
+		},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	addHosts(template, hosts)
+	return issueCert(ca.Cert, ca.Key, template, ca.LeafTTL)
+}
+
+// issueCert signs template with a fresh P256 key, filling in the serial number and a
+// validity of ttl capped at the CA's own expiry. The returned chain includes caCert.
+func issueCert(caCert *x509.Certificate, caKey crypto.Signer, template *x509.Certificate, ttl time.Duration) (*tls.Certificate, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serialNumber
+	template.NotBefore = time.Now().Add(-time.Minute)
+	template.NotAfter = template.NotBefore.Add(ttl)
+	if template.NotAfter.After(caCert.NotAfter) {
+		template.NotAfter = caCert.NotAfter
+	}
+	template.BasicConstraintsValid = true
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &priv.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, caCert.Raw},
+		PrivateKey:  priv,
+		Leaf:        leaf,
+	}, nil
+}
+
+func createDevCAOnce(dir, certPath, keyPath, name string) ([]byte, error) {
+	unlock, err := lockDevCADir(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	// another process may have created the CA while this one waited for the lock
+	certPEM, err := os.ReadFile(certPath)
+	if !errors.Is(err, os.ErrNotExist) {
+		return certPEM, err
+	}
+	if err := createDevCA(certPath, keyPath, name); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(certPath)
+}
+
+// lockDevCADir takes the lock file of dir, waiting while another process holds it. The
+// returned func removes the lock only if it is still this process's.
+func lockDevCADir(dir string) (func(), error) {
+	path := filepath.Join(dir, devCALockFile)
+	token := rand.Text()
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			_, err = f.WriteString(token)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("acquiring dev CA lock: %w", err)
+			}
+			return func() {
+				if b, err := os.ReadFile(path); err == nil && string(b) == token {
+					os.Remove(path)
+				}
+			}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("acquiring dev CA lock: %w", err)
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > devCAStaleLockAge {
+			breakStaleDevCALock(path)
+			continue
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// breakStaleDevCALock moves the lock out of the way under a name of its own, so only one
+// waiter breaks it, and puts it back if it was replaced by a fresh lock in the meantime.
+func breakStaleDevCALock(path string) {
+	moved := path + "." + rand.Text() + ".stale"
+	if err := os.Rename(path, moved); err != nil {
+		return
+	}
+	if info, err := os.Stat(moved); err == nil && time.Since(info.ModTime()) <= devCAStaleLockAge {
+		// link fails rather than replace a lock taken in the meantime
+		_ = os.Link(moved, path)
+	}
+	os.Remove(moved)
+}
+
+func createDevCA(certPath, keyPath, name string) error {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate private key: %w", err)
+	}
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to marshal public key: %w", err)
+	}
+	skid := sha1.Sum(pubDER)
+	notBefore := time.Now().Add(-time.Minute)
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{name},
+			CommonName:   name,
+		},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(devCATTL),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+		SubjectKeyId:          skid[:],
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	// the key goes first so a cert on disk always has its key next to it
+	if err := writeFileAtomic(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return writeFileAtomic(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// parseCertAndKey parses the first certificate of certPEM and an EC private key, checking
+// the two belong together.
+func parseCertAndKey(certPEM, keyPEM []byte) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil || certBlock.Type != "CERTIFICATE" {
+		return nil, nil, errors.New("no certificate PEM block")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, errors.New("no private key PEM block")
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := VerifyKeyMatch(cert, key); err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func newSerialNumber() (*big.Int, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serialNumber, nil
+}
+
+// addHosts adds hosts to the template as IP address or DNS name SANs.
+func addHosts(template *x509.Certificate, hosts []string) {
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+}
+
+func leafKey(hosts []string) string {
+	sorted := slices.Clone(hosts)
+	slices.Sort(sorted)
+	return strings.Join(slices.Compact(sorted), ",")
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
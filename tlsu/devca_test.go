@@ -0,0 +1,168 @@
+package tlsu
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDevCAPersistsAcrossLoads(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := LoadOrCreateDevCAInDir(dir, "test development CA")
+	if err != nil {
+		t.Fatalf("creating CA: %v", err)
+	}
+	reloaded, err := LoadOrCreateDevCAInDir(dir, "ignored")
+	if err != nil {
+		t.Fatalf("reloading CA: %v", err)
+	}
+	if !bytes.Equal(ca.CertPEM(), reloaded.CertPEM()) {
+		t.Fatal("reloading created a new root")
+	}
+	if reloaded.Cert.Subject.CommonName != "test development CA" {
+		t.Fatalf("unexpected CA name %q", reloaded.Cert.Subject.CommonName)
+	}
+	info, err := os.Stat(dir + "/" + devCAKeyFile)
+	if err != nil {
+		t.Fatalf("os.Stat: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("CA key has mode %v", info.Mode().Perm())
+	}
+
+	exported := dir + "/exported.pem"
+	if err := ca.ExportCertPEM(exported); err != nil {
+		t.Fatalf("ExportCertPEM: %v", err)
+	}
+	pool := x509.NewCertPool()
+	data, _ := os.ReadFile(exported)
+	if !pool.AppendCertsFromPEM(data) {
+		t.Fatal("exported PEM holds no certificate")
+	}
+}
+
+func TestDevCALeafTrustedByClients(t *testing.T) {
+	ca, err := LoadOrCreateDevCAInDir(t.TempDir(), "test development CA")
+	if err != nil {
+		t.Fatalf("LoadOrCreateDevCAInDir: %v", err)
+	}
+	// httptest.Server.StartTLS would add its own certificate, taking precedence for
+	// clients that don't send SNI
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: ca.GetCertificate("localhost", "127.0.0.1")})
+	if err != nil {
+		t.Fatalf("tls.Listen: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}), ErrorLog: log.New(io.Discard, "", 0)}
+	go server.Serve(listener)
+	defer server.Close()
+	url := "https://" + listener.Addr().String()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.CertPool()}}}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("request with the CA as root failed: %v", err)
+	}
+	resp.Body.Close()
+
+	untrusting := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: x509.NewCertPool()}}}
+	if _, err := untrusting.Get(url); err == nil {
+		t.Fatal("expected a client without the CA to reject the leaf")
+	}
+}
+
+func TestDevCALeafRenewal(t *testing.T) {
+	ca, err := LoadOrCreateDevCAInDir(t.TempDir(), "test development CA")
+	if err != nil {
+		t.Fatalf("LoadOrCreateDevCAInDir: %v", err)
+	}
+	first, err := ca.Leaf("example.test", "::1")
+	if err != nil {
+		t.Fatalf("Leaf: %v", err)
+	}
+	if _, err := first.Leaf.Verify(x509.VerifyOptions{Roots: ca.CertPool(), DNSName: "example.test"}); err != nil {
+		t.Fatalf("leaf does not chain to the CA: %v", err)
+	}
+	if cached, _ := ca.Leaf("::1", "example.test"); cached != first {
+		t.Fatal("expected the cached leaf for the same hosts")
+	}
+	certFile, _, err := ca.LeafFiles("example.test")
+	if err != nil {
+		t.Fatalf("LeafFiles: %v", err)
+	}
+	stored, _ := os.ReadFile(certFile)
+	if again, _, _ := ca.LeafFiles("example.test"); again != certFile {
+		t.Fatal("expected the same leaf files")
+	}
+	if reread, _ := os.ReadFile(certFile); !bytes.Equal(stored, reread) {
+		t.Fatal("leaf files rewritten while still valid")
+	}
+
+	ca.RenewBefore = ca.LeafTTL + time.Hour
+	renewed, err := ca.Leaf("example.test", "::1")
+	if err != nil {
+		t.Fatalf("Leaf: %v", err)
+	}
+	if renewed == first {
+		t.Fatal("expected a leaf within RenewBefore of expiry to be re-issued")
+	}
+	if _, _, err := ca.LeafFiles("example.test"); err != nil {
+		t.Fatalf("LeafFiles: %v", err)
+	}
+	if reread, _ := os.ReadFile(certFile); bytes.Equal(stored, reread) {
+		t.Fatal("expected leaf files within RenewBefore of expiry to be rewritten")
+	}
+}
+
+func TestDevCAConcurrentCreationAgreesOnOneRoot(t *testing.T) {
+	dir := t.TempDir()
+	// a lock left by a crashed process is broken once stale
+	lockPath := filepath.Join(dir, devCALockFile)
+	if err := os.WriteFile(lockPath, []byte("crashed"), 0600); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(lockPath, old, old); err != nil {
+		t.Fatalf("os.Chtimes: %v", err)
+	}
+
+	cas := make([]*DevCA, 8)
+	var wg sync.WaitGroup
+	for i := range cas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ca, err := LoadOrCreateDevCAInDir(dir, "test development CA")
+			if err != nil {
+				t.Errorf("LoadOrCreateDevCAInDir: %v", err)
+				return
+			}
+			cas[i] = ca
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+	onDisk, err := LoadOrCreateDevCAInDir(dir, "ignored")
+	if err != nil {
+		t.Fatalf("LoadOrCreateDevCAInDir: %v", err)
+	}
+	for _, ca := range cas {
+		if !bytes.Equal(ca.CertPEM(), onDisk.CertPEM()) {
+			t.Fatal("concurrent loads created different roots")
+		}
+	}
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Fatalf("lock file left behind: %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package tlsu
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+var ErrKeyMismatch = errors.New("private key does not match certificate")
+var ErrCertExpired = errors.New("certificate expired")
+var ErrCertNotYetValid = errors.New("certificate not yet valid")
+
+// CertInfo is a readable summary of a certificate, e.g. for logs and health endpoints.
+type CertInfo struct {
+	Subject     string
+	Issuer      string
+	DNSNames    []string
+	IPAddresses []net.IP
+	URIs        []string
+	Serial      string
+	NotBefore   time.Time
+	NotAfter    time.Time
+	IsCA        bool
+	// Fingerprint is the hex SHA-256 of the DER certificate.
+	Fingerprint string
+}
+
+func DescribeCert(cert *x509.Certificate) CertInfo {
+	info := CertInfo{
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		DNSNames:    cert.DNSNames,
+		IPAddresses: cert.IPAddresses,
+		Serial:      cert.SerialNumber.Text(16),
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+		IsCA:        cert.IsCA,
+	}
+	for _, u := range cert.URIs {
+		info.URIs = append(info.URIs, u.String())
+	}
+	sum := sha256.Sum256(cert.Raw)
+	info.Fingerprint = hex.EncodeToString(sum[:])
+	return info
+}
+
+// ExpiresIn returns the time left until NotAfter, negative once expired.
+func (i CertInfo) ExpiresIn() time.Duration {
+	return time.Until(i.NotAfter)
+}
+
+// ParseCertChainPEM parses every CERTIFICATE block in data, leaf first as in a
+// TLS_CERT_FILE. Other block types are skipped.
+func ParseCertChainPEM(data []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing certificate %d: %w", len(chain), err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no certificate PEM block")
+	}
+	return chain, nil
+}
+
+// InspectCertFile describes each certificate of a PEM chain file.
+func InspectCertFile(certFile string) ([]CertInfo, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := ParseCertChainPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", certFile, err)
+	}
+	infos := make([]CertInfo, len(chain))
+	for i, cert := range chain {
+		infos[i] = DescribeCert(cert)
+	}
+	return infos, nil
+}
+
+// ParsePrivateKeyPEM parses the first private key block in data in PKCS#8, PKCS#1 or
+// SEC 1 (EC PRIVATE KEY) form.
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no private key PEM block")
+		}
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			signer, ok := key.(crypto.Signer)
+			if !ok {
+				return nil, fmt.Errorf("unsupported private key type %T", key)
+			}
+			return signer, nil
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(block.Bytes)
+		}
+	}
+}
+
+// VerifyKeyMatch returns ErrKeyMismatch when key is not the private key of cert.
+func VerifyKeyMatch(cert *x509.Certificate, key crypto.Signer) error {
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return ErrKeyMismatch
+	}
+	return nil
+}
+
+// VerifyValidity returns ErrCertExpired or ErrCertNotYetValid when now is outside the
+// validity of cert.
+func VerifyValidity(cert *x509.Certificate, now time.Time) error {
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("%w: %s expired at %s", ErrCertExpired, cert.Subject, cert.NotAfter.Format(time.RFC3339))
+	}
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("%w: %s valid from %s", ErrCertNotYetValid, cert.Subject, cert.NotBefore.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// VerifyChain checks the chain, leaf first, against roots, using the rest of the chain as
+// intermediates. A nil roots uses the system pool, and an empty dnsName skips the
+// hostname check.
+func VerifyChain(chain []*x509.Certificate, roots *x509.CertPool, dnsName string) error {
+	if len(chain) == 0 {
+		return errors.New("empty certificate chain")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       dnsName,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// CheckKeyPairFiles loads a cert chain and key, checking the key matches the leaf and
+// every certificate in the chain is currently valid.
+func CheckKeyPairFiles(certFile, keyFile string) error {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+	chain, err := ParseCertChainPEM(certPEM)
+	if err != nil {
+		return fmt.Errorf("%s: %w", certFile, err)
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	key, err := ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return fmt.Errorf("%s: %w", keyFile, err)
+	}
+	if err := VerifyKeyMatch(chain[0], key); err != nil {
+		return fmt.Errorf("%s and %s: %w", certFile, keyFile, err)
+	}
+	now := time.Now()
+	for _, cert := range chain {
+		if err := VerifyValidity(cert, now); err != nil {
+			return fmt.Errorf("%s: %w", certFile, err)
+		}
+	}
+	return nil
+}
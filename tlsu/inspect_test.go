@@ -0,0 +1,134 @@
+package tlsu
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInspectCertFile(t *testing.T) {
+	ca, err := LoadOrCreateDevCAInDir(t.TempDir(), "test development CA")
+	if err != nil {
+		t.Fatalf("LoadOrCreateDevCAInDir: %v", err)
+	}
+	certFile, keyFile, err := ca.LeafFiles("example.test", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("LeafFiles: %v", err)
+	}
+	infos, err := InspectCertFile(certFile)
+	if err != nil {
+		t.Fatalf("InspectCertFile: %v", err)
+	}
+	if len(infos) != 2 || !infos[1].IsCA {
+		t.Fatalf("expected leaf and root, got %+v", infos)
+	}
+	leaf := infos[0]
+	if leaf.Subject != "CN=example.test,O=test development CA" || leaf.Issuer != infos[1].Subject {
+		t.Fatalf("unexpected subject %q or issuer %q", leaf.Subject, leaf.Issuer)
+	}
+	if len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != "example.test" || len(leaf.IPAddresses) != 1 || leaf.IPAddresses[0].String() != "10.0.0.1" {
+		t.Fatalf("unexpected SANs %v %v", leaf.DNSNames, leaf.IPAddresses)
+	}
+	if leaf.ExpiresIn() <= ca.RenewBefore {
+		t.Fatalf("unexpected validity %v", leaf.ExpiresIn())
+	}
+
+	if err := CheckKeyPairFiles(certFile, keyFile); err != nil {
+		t.Fatalf("expected a valid pair: %v", err)
+	}
+	_, otherKeyFile, err := ca.LeafFiles("other.test")
+	if err != nil {
+		t.Fatalf("LeafFiles: %v", err)
+	}
+	if err := CheckKeyPairFiles(certFile, otherKeyFile); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("expected ErrKeyMismatch, got %v", err)
+	}
+
+	data, _ := os.ReadFile(certFile)
+	chain, err := ParseCertChainPEM(data)
+	if err != nil {
+		t.Fatalf("ParseCertChainPEM: %v", err)
+	}
+	if err := VerifyChain(chain, ca.CertPool(), "example.test"); err != nil {
+		t.Fatalf("expected the chain to verify: %v", err)
+	}
+	if err := VerifyChain(chain, x509.NewCertPool(), ""); err == nil {
+		t.Fatal("expected the chain to fail against an empty pool")
+	}
+}
+
+func TestResolveOrCreateTLSFilesStrict(t *testing.T) {
+	ca, err := LoadOrCreateDevCAInDir(t.TempDir(), "test development CA")
+	if err != nil {
+		t.Fatalf("LoadOrCreateDevCAInDir: %v", err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeTestPair(t, ca, certFile, keyFile, -30*time.Second)
+	t.Setenv(TlsCertFileEnvVar, certFile)
+	t.Setenv(TlsPrivateKeyFileEnvVar, keyFile)
+
+	if k, c, err := ResolveOrCreateTLSFiles(); err != nil || k != keyFile || c != certFile {
+		t.Fatalf("expected the env files without strict checks, got %q %q %v", k, c, err)
+	}
+	if _, _, err := ResolveOrCreateTLSFiles(WithStrictCheck()); !errors.Is(err, ErrCertExpired) {
+		t.Fatalf("expected ErrCertExpired, got %v", err)
+	}
+	writeTestPair(t, ca, certFile, keyFile, time.Hour)
+	if _, _, err := ResolveOrCreateTLSFiles(WithStrictCheck()); err != nil {
+		t.Fatalf("ResolveOrCreateTLSFiles: %v", err)
+	}
+}
+
+func TestExpiryMonitorCheck(t *testing.T) {
+	ca, err := LoadOrCreateDevCAInDir(t.TempDir(), "test development CA")
+	if err != nil {
+		t.Fatalf("LoadOrCreateDevCAInDir: %v", err)
+	}
+	issue := func(name string, ttl time.Duration) *x509.Certificate {
+		cert, err := issueCert(ca.Cert, ca.Key, &x509.Certificate{Subject: pkix.Name{CommonName: name}}, ttl)
+		if err != nil {
+			t.Fatalf("issueCert: %v", err)
+		}
+		return cert.Leaf
+	}
+	certs := []*x509.Certificate{issue("fresh", 90*24*time.Hour), issue("expiring", 24*time.Hour), issue("expired", 30*time.Second)}
+
+	var logs bytes.Buffer
+	var called []string
+	monitor := NewExpiryMonitor("", func(ctx context.Context, cert CertInfo) {
+		called = append(called, cert.Subject)
+	})
+	monitor.Certs = func() ([]*x509.Certificate, error) { return certs, nil }
+	monitor.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	expiring := monitor.Check(context.Background())
+	if len(expiring) != 2 || strings.Join(called, ",") != "CN=expiring,CN=expired" {
+		t.Fatalf("unexpected expiring certs %v", called)
+	}
+	out := logs.String()
+	if !strings.Contains(out, `level=WARN msg="certificate expiring" subject="CN=expiring"`) ||
+		!strings.Contains(out, `level=ERROR msg="certificate expired" subject="CN=expired"`) {
+		t.Fatalf("unexpected logs:\n%s", out)
+	}
+
+	logs.Reset()
+	ctx, cancel := context.WithCancel(context.Background())
+	missing := NewExpiryMonitor(filepath.Join(t.TempDir(), "missing.pem"), nil)
+	missing.Logger = monitor.Logger
+	// a zero Interval falls back to the default instead of panicking in the ticker
+	missing.Interval = 0
+	cancel()
+	missing.Run(ctx)
+	if !strings.Contains(logs.String(), "certificate check failed") {
+		t.Fatalf("expected a failed check to be logged, got:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,90 @@
+package tlsu
+
+import (
+	"context"
+	"crypto/x509"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/jptrs93/goutil/timeu"
+)
+
+// ExpiryMonitor periodically checks certificates, logging a warning for each one within
+// WarnBefore of expiring, an error for expired ones, and calling OnExpiring for both.
+type ExpiryMonitor struct {
+	// Certs returns the certificates to check, e.g. re-reading a cert file so renewals
+	// are picked up.
+	Certs      func() ([]*x509.Certificate, error)
+	WarnBefore time.Duration
+	Interval   time.Duration
+	Logger     *slog.Logger
+	OnExpiring func(ctx context.Context, cert CertInfo)
+}
+
+// NewExpiryMonitor monitors the chain in certFile, re-read on every check.
+func NewExpiryMonitor(certFile string, onExpiring func(ctx context.Context, cert CertInfo)) *ExpiryMonitor {
+	return &ExpiryMonitor{
+		Certs: func() ([]*x509.Certificate, error) {
+			data, err := os.ReadFile(certFile)
+			if err != nil {
+				return nil, err
+			}
+			return ParseCertChainPEM(data)
+		},
+		WarnBefore: 14 * 24 * time.Hour,
+		Interval:   time.Hour,
+		OnExpiring: onExpiring,
+	}
+}
+
+// Run checks immediately and then every Interval, an hour when not set, until ctx is
+// done.
+func (m *ExpiryMonitor) Run(ctx context.Context) {
+	interval := m.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := timeu.NewOffsetTicker(interval, time.Now().Add(interval), 0)
+	defer ticker.Stop()
+	for {
+		m.Check(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check checks the certificates once, returning those within WarnBefore of expiring or
+// expired.
+func (m *ExpiryMonitor) Check(ctx context.Context) []CertInfo {
+	logger := m.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	certs, err := m.Certs()
+	if err != nil {
+		logger.ErrorContext(ctx, "certificate check failed", "error", err)
+		return nil
+	}
+	var expiring []CertInfo
+	now := time.Now()
+	for _, cert := range certs {
+		if now.Before(cert.NotAfter.Add(-m.WarnBefore)) {
+			continue
+		}
+		info := DescribeCert(cert)
+		expiring = append(expiring, info)
+		if now.After(cert.NotAfter) {
+			logger.ErrorContext(ctx, "certificate expired", "subject", info.Subject, "not_after", info.NotAfter, "fingerprint", info.Fingerprint)
+		} else {
+			logger.WarnContext(ctx, "certificate expiring", "subject", info.Subject, "not_after", info.NotAfter, "expires_in", info.NotAfter.Sub(now).Round(time.Minute), "fingerprint", info.Fingerprint)
+		}
+		if m.OnExpiring != nil {
+			m.OnExpiring(ctx, info)
+		}
+	}
+	return expiring
+}
@@ -0,0 +1,126 @@
+package tlsu
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+type peerContextKey struct{}
+
+// ClientCertRequest describes a client certificate for a service. Services are
+// identified by their URI SANs, typically a SPIFFEID.
+type ClientCertRequest struct {
+	CommonName string
+	URIs       []string
+	TTL        time.Duration
+}
+
+// SPIFFEID returns a SPIFFE style ID like spiffe://example.org/ns/prod/sa/billing.
+func SPIFFEID(trustDomain string, segments ...string) string {
+	return (&url.URL{Scheme: "spiffe", Host: trustDomain, Path: path.Join(append([]string{"/"}, segments...)...)}).String()
+}
+
+// IssueClientCert issues a certificate for client authentication signed by the CA. TTL
+// defaults to 24 hours, as client certs are cheap to re-issue and can't be revoked.
+func IssueClientCert(caCert *x509.Certificate, caKey crypto.Signer, req ClientCertRequest) (*tls.Certificate, error) {
+	if req.CommonName == "" && len(req.URIs) == 0 {
+		return nil, errors.New("client certificate needs a common name or URI")
+	}
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: req.CommonName},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, raw := range req.URIs {
+		u, err := url.Parse(raw)
+		if err != nil || u.Scheme == "" {
+			return nil, fmt.Errorf("invalid URI SAN %q", raw)
+		}
+		template.URIs = append(template.URIs, u)
+	}
+	ttl := req.TTL
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return issueCert(caCert, caKey, template, ttl)
+}
+
+// ClientCert issues a client certificate signed by the dev CA.
+func (ca *DevCA) ClientCert(req ClientCertRequest) (*tls.Certificate, error) {
+	return IssueClientCert(ca.Cert, ca.Key, req)
+}
+
+// MTLSServerConfig returns a DefaultServerConfig requiring client certificates that
+// verify against clientCAs. Set Certificates or GetCertificate on the result.
+func MTLSServerConfig(clientCAs *x509.CertPool) *tls.Config {
+	config := DefaultServerConfig()
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	config.ClientCAs = clientCAs
+	return config
+}
+
+// MTLSClientConfig returns a client config presenting cert and trusting only rootCAs.
+func MTLSClientConfig(cert *tls.Certificate, rootCAs *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*cert},
+		RootCAs:      rootCAs,
+	}
+}
+
+// PeerIdentity is the identity of a client taken from its verified certificate.
+type PeerIdentity struct {
+	CommonName string
+	URIs       []*url.URL
+	// SPIFFEID is the first spiffe:// URI SAN, if any.
+	SPIFFEID    string
+	Certificate *x509.Certificate
+}
+
+// PeerIdentityFromRequest returns the identity of the verified client certificate of r.
+// Certificates the server only received without verifying them are ignored.
+func PeerIdentityFromRequest(r *http.Request) (PeerIdentity, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return PeerIdentity{}, false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	identity := PeerIdentity{
+		CommonName:  cert.Subject.CommonName,
+		URIs:        cert.URIs,
+		Certificate: cert,
+	}
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			identity.SPIFFEID = u.String()
+			break
+		}
+	}
+	return identity, true
+}
+
+// PeerIdentityMiddleware puts the verified peer identity in the request context for
+// PeerIdentityFromContext, responding 401 to requests without one.
+func PeerIdentityMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := PeerIdentityFromRequest(r)
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), peerContextKey{}, identity)))
+	})
+}
+
+func PeerIdentityFromContext(ctx context.Context) (PeerIdentity, bool) {
+	identity, ok := ctx.Value(peerContextKey{}).(PeerIdentity)
+	return identity, ok
+}
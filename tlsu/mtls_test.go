@@ -0,0 +1,81 @@
+package tlsu
+
+import (
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestMTLSPeerIdentity(t *testing.T) {
+	ca, err := LoadOrCreateDevCAInDir(t.TempDir(), "test development CA")
+	if err != nil {
+		t.Fatalf("LoadOrCreateDevCAInDir: %v", err)
+	}
+	serverConfig := MTLSServerConfig(ca.CertPool())
+	serverConfig.GetCertificate = ca.GetCertificate("localhost")
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatalf("tls.Listen: %v", err)
+	}
+	server := &http.Server{Handler: PeerIdentityMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ := PeerIdentityFromContext(r.Context())
+		_, _ = io.WriteString(w, identity.SPIFFEID)
+	})), ErrorLog: log.New(io.Discard, "", 0)}
+	go server.Serve(listener)
+	defer server.Close()
+	url := "https://localhost:" + portOf(t, listener.Addr().String())
+
+	spiffeID := SPIFFEID("example.org", "ns", "prod", "sa", "billing")
+	if spiffeID != "spiffe://example.org/ns/prod/sa/billing" {
+		t.Fatalf("unexpected SPIFFE ID %q", spiffeID)
+	}
+	clientCert, err := ca.ClientCert(ClientCertRequest{CommonName: "billing", URIs: []string{spiffeID}})
+	if err != nil {
+		t.Fatalf("ClientCert: %v", err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: MTLSClientConfig(clientCert, ca.CertPool())}}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("request with client certificate: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != spiffeID {
+		t.Fatalf("expected peer %q, got %q", spiffeID, body)
+	}
+
+	// a server certificate from the same CA is not valid for client auth
+	serverCert, err := ca.Leaf("billing.internal")
+	if err != nil {
+		t.Fatalf("Leaf: %v", err)
+	}
+	wrongUsage := &http.Client{Transport: &http.Transport{TLSClientConfig: MTLSClientConfig(serverCert, ca.CertPool())}}
+	if _, err := wrongUsage.Get(url); err == nil {
+		t.Fatal("expected a server certificate to be rejected as client certificate")
+	}
+
+	other, err := LoadOrCreateDevCAInDir(t.TempDir(), "other CA")
+	if err != nil {
+		t.Fatalf("LoadOrCreateDevCAInDir: %v", err)
+	}
+	foreignCert, err := other.ClientCert(ClientCertRequest{URIs: []string{spiffeID}})
+	if err != nil {
+		t.Fatalf("ClientCert: %v", err)
+	}
+	foreign := &http.Client{Transport: &http.Transport{TLSClientConfig: MTLSClientConfig(foreignCert, ca.CertPool())}}
+	if _, err := foreign.Get(url); err == nil {
+		t.Fatal("expected a client certificate from another CA to be rejected")
+	}
+}
+
+func portOf(t *testing.T, addr string) string {
+	t.Helper()
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("net.SplitHostPort: %v", err)
+	}
+	return port
+}
@@ -4,12 +4,11 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
-	"math/big"
-	"net"
 	"os"
 	"os/signal"
 	"syscall"
@@ -21,67 +20,59 @@ const (
 	TlsCertFileEnvVar       = "TLS_CERT_FILE"
 )
 
-func MustResolveOrCreateTLSFiles() (string, string) {
-	k, c, err := ResolveOrCreateTLSFiles()
+type ResolveOption func(*resolveConfig)
+
+type resolveConfig struct {
+	strict bool
+}
+
+// WithStrictCheck makes ResolveOrCreateTLSFiles refuse an env configured pair whose key
+// doesn't match or whose chain isn't currently valid, see CheckKeyPairFiles.
+func WithStrictCheck() ResolveOption {
+	return func(c *resolveConfig) {
+		c.strict = true
+	}
+}
+
+func MustResolveOrCreateTLSFiles(opts ...ResolveOption) (string, string) {
+	k, c, err := ResolveOrCreateTLSFiles(opts...)
 	if err != nil {
 		panic(err)
 	}
 	return k, c
 }
 
-func ResolveOrCreateTLSFiles() (string, string, error) {
-	keyFile := os.Getenv(TlsPrivateKeyFileEnvVar)
-	certFile := os.Getenv(TlsCertFileEnvVar)
+var defaultHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+// ResolveOrCreateTLSFiles returns the key and cert file paths from the environment, or
+// of a temporary self-signed pair. NewServerTLSConfig avoids the temporary files.
+func ResolveOrCreateTLSFiles(opts ...ResolveOption) (keyFile, certFile string, err error) {
+	config := resolveConfig{}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	keyFile = os.Getenv(TlsPrivateKeyFileEnvVar)
+	certFile = os.Getenv(TlsCertFileEnvVar)
 	if keyFile != "" && certFile != "" {
+		if config.strict {
+			if err := CheckKeyPairFiles(certFile, keyFile); err != nil {
+				return "", "", err
+			}
+		}
 		return keyFile, certFile, nil
 	}
-	return GenerateSelfSignedCert("localhost", "127.0.0.1", "::1")
+	certFile, keyFile, err = GenerateSelfSignedCert(defaultHosts...)
+	return keyFile, certFile, err
 }
 
 // GenerateSelfSignedCert creates a self-signed certificate for development
+// that nothing trusts; prefer a DevCA leaf to avoid certificate warnings.
 // Returns the paths to the generated temporary cert and key files
 // These files will be automatically deleted when the process exits
 func GenerateSelfSignedCert(hosts ...string) (certFile, keyFile string, err error) {
-	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to generate private key: %w", err)
-	}
-
-	notBefore := time.Now()
-	notAfter := notBefore.Add(365 * 24 * time.Hour) // Valid for 1 year
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to generate serial number: %w", err)
-	}
-
-	// Create certificate template
-	template := x509.Certificate{
-		SerialNumber: serialNumber,
-		Subject: pkix.Name{
-			Organization: []string{"Development"},
-			CommonName:   "localhost",
-		},
-		NotBefore:             notBefore,
-		NotAfter:              notAfter,
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		BasicConstraintsValid: true,
-	}
-
-	// Add hosts (DNS names and IP addresses)
-	for _, h := range hosts {
-		if ip := net.ParseIP(h); ip != nil {
-			template.IPAddresses = append(template.IPAddresses, ip)
-		} else {
-			template.DNSNames = append(template.DNSNames, h)
-		}
-	}
-
-	// Create the certificate
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	derBytes, priv, err := newSelfSignedCert(hosts)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create certificate: %w", err)
+		return "", "", err
 	}
 
 	// Create temporary certificate file
@@ -135,3 +126,55 @@ func GenerateSelfSignedCert(hosts ...string) (certFile, keyFile string, err erro
 
 	return certFile, keyFile, nil
 }
+
+// SelfSignedCertificate is GenerateSelfSignedCert without touching disk, for use in
+// tls.Config.Certificates.
+func SelfSignedCertificate(hosts ...string) (*tls.Certificate, error) {
+	derBytes, priv, err := newSelfSignedCert(hosts)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{derBytes}, PrivateKey: priv, Leaf: leaf}, nil
+}
+
+func newSelfSignedCert(hosts []string) ([]byte, *ecdsa.PrivateKey, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+
+	notBefore := time.Now()
+	notAfter := notBefore.Add(365 * 24 * time.Hour) // Valid for 1 year
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Create certificate template
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{"Development"},
+			CommonName:   "localhost",
+		},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	// Add hosts (DNS names and IP addresses)
+	addHosts(&template, hosts)
+
+	// Create the certificate
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	return derBytes, priv, nil
+}